@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditCapturesBodyOnlyForBodyRules(t *testing.T) {
+	a := NewAuditor(AuditConfig{Rules: []AuditRule{
+		{PathPattern: PathPattern{Path: "/orders", Methods: []string{http.MethodPost}}, Resources: map[string]string{"id": "body:id"}},
+		{PathPattern: PathPattern{Path: "/users/{id}", Route: true, Methods: []string{http.MethodPut}}, Resources: map[string]string{"id": "body:id"}},
+		{PathPattern: PathPattern{Path: "/users", Match: MatchPrefix}, Resources: map[string]string{"id": "query:id"}},
+	}}, nil)
+	tests := []struct {
+		method  string
+		path    string
+		capture bool
+	}{
+		{http.MethodPost, "/orders", true},
+		{http.MethodPost, "/users", false},
+		{http.MethodPost, "/items", false},
+		// the route rule may match once ServeMux routes the request
+		{http.MethodPut, "/users/1", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"id":"1"}`))
+		if body := a.captureBody(r); (body != nil) != tt.capture {
+			t.Errorf("%s %s: captured %v, want %v", tt.method, tt.path, body != nil, tt.capture)
+		}
+	}
+}
+
+func TestAuditEventAnonymizesClientIP(t *testing.T) {
+	rules := []AuditRule{{PathPattern: PathPattern{Path: "/orders"}}}
+	r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	r.RemoteAddr = "192.0.2.15:4711"
+	a := NewAuditor(AuditConfig{Rules: rules, IpConfig: IpConfig{IpMode: IpTruncate}}, nil)
+	if e := a.BuildAuditEvent(r, &a.rules[0], nil, nil, http.StatusOK, false); e.ClientIP != "192.0.2.0" {
+		t.Fatalf("client ip %q, want 192.0.2.0", e.ClientIP)
+	}
+	a = NewAuditor(AuditConfig{Rules: rules, IpConfig: IpConfig{IpMode: IpDrop}}, nil)
+	if e := a.BuildAuditEvent(r, &a.rules[0], nil, nil, http.StatusOK, false); len(e.ClientIP) > 0 {
+		t.Fatalf("client ip %q, want it dropped", e.ClientIP)
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildContextReadsBodyOnlyWithBody(t *testing.T) {
+	l := NewHttpLogger(LogConfig{Map: map[string]string{"userId": "id"}}, nil, nil, nil)
+	for _, test := range []struct {
+		method string
+		mapped bool
+	}{
+		{"POST", true},
+		{"PUT", true},
+		{"GET", false},
+		{"DELETE", false},
+	} {
+		var userId interface{}
+		var body string
+		h := l.BuildContextWithMask(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userId = r.Context().Value("userId")
+			data, _ := io.ReadAll(r.Body)
+			body = string(data)
+		}))
+		r := httptest.NewRequest(test.method, "/", strings.NewReader(`{"id":"1"}`))
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if mapped := userId == "1"; mapped != test.mapped {
+			t.Errorf("%s: userId %v, mapped from the body %v, want %v", test.method, userId, mapped, test.mapped)
+		}
+		if body != `{"id":"1"}` {
+			t.Errorf("%s: body %q not kept for the handler", test.method, body)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeferredResponseBufferIsBounded(t *testing.T) {
+	d, err := CompileDeferredConfig(&DeferredConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewResponseWriter(httptest.NewRecorder(), DeferredLimit(0, d))
+	w.Write(bytes.Repeat([]byte("a"), DefaultDeferredLimit+100))
+	if w.Body.Len() != DefaultDeferredLimit {
+		t.Fatalf("buffered %d bytes, want %d", w.Body.Len(), DefaultDeferredLimit)
+	}
+}
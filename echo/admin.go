@@ -0,0 +1,190 @@
+package echo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DefaultCaptureMinutes = 10
+
+// AdminChange is a change of the logging config. Sampling is a SamplingConfig, or null to disable sampling.
+type AdminChange struct {
+	Log       *bool           `json:"log,omitempty"`
+	Skips     *string         `json:"skips,omitempty"`
+	SkipRules *[]PathPattern  `json:"skipRules,omitempty"`
+	Sampling  json.RawMessage `json:"sampling,omitempty"`
+	Masks     *string         `json:"masks,omitempty"`
+	Capture   *AdminCapture   `json:"capture,omitempty"`
+}
+
+// AdminCapture enables the full-body capture of a route or a client IP for Minutes, 10 by default.
+type AdminCapture struct {
+	PathPattern
+	Ip      string `json:"ip,omitempty"`
+	Minutes int    `json:"minutes,omitempty"`
+}
+
+// AdminHandler shows and changes the config of a ConfigHolder at runtime.
+// GET returns the effective config, with secrets redacted. POST or PATCH apply an AdminChange and return the new config.
+// Each change, and the expiry of each capture, is sent as an audit entry by Send, with the "time", "level" and "msg" keys renamed by KeyMap.
+type AdminHandler struct {
+	Holder *ConfigHolder
+	send   func(context.Context, []byte, map[string]string) error
+	KeyMap map[string]string
+	// Actor returns who makes a change, such as the subject of its JWT.
+	Actor func(r *http.Request) string
+	// MaxCapture limits the duration of captures, if it is positive.
+	MaxCapture time.Duration
+	mu         sync.Mutex
+}
+
+func NewAdminHandler(holder *ConfigHolder, send func(context.Context, []byte, map[string]string) error, options ...map[string]string) *AdminHandler {
+	var keyMap map[string]string
+	if len(options) >= 1 {
+		keyMap = options[0]
+	}
+	return &AdminHandler{Holder: holder, send: send, KeyMap: keyMap}
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		c, _ := h.Holder.Load()
+		writeAdminJSON(w, http.StatusOK, RedactConfig(c))
+	case http.MethodPost, http.MethodPatch:
+		var change AdminChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			http.Error(w, "invalid change: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		c, err := h.apply(r, change)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, RedactConfig(c))
+	default:
+		w.Header().Set("Allow", "GET, POST, PATCH")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *AdminHandler) apply(r *http.Request, change AdminChange) (LogConfig, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, _ := h.Holder.Load()
+	if change.Log != nil {
+		c.Log = *change.Log
+	}
+	if change.Skips != nil {
+		c.Skips = *change.Skips
+	}
+	if change.SkipRules != nil {
+		c.SkipRules = *change.SkipRules
+	}
+	if len(change.Sampling) > 0 {
+		var sampling *SamplingConfig
+		if err := json.Unmarshal(change.Sampling, &sampling); err != nil {
+			return c, err
+		}
+		c.Sampling = sampling
+	}
+	if change.Masks != nil {
+		c.Masks = *change.Masks
+	}
+	var duration time.Duration
+	if change.Capture != nil {
+		minutes := change.Capture.Minutes
+		if minutes <= 0 {
+			minutes = DefaultCaptureMinutes
+		}
+		duration = time.Duration(minutes) * time.Minute
+		if h.MaxCapture > 0 && duration > h.MaxCapture {
+			duration = h.MaxCapture
+		}
+		rule := CaptureRule{PathPattern: change.Capture.PathPattern, Ip: change.Capture.Ip, Until: time.Now().Add(duration)}
+		c.Captures = append(append([]CaptureRule(nil), c.Captures...), rule)
+	}
+	if err := h.Holder.Update(c); err != nil {
+		return c, err
+	}
+	if duration > 0 {
+		time.AfterFunc(duration, h.expireCaptures)
+	}
+	actor := ""
+	if h.Actor != nil {
+		actor = h.Actor(r)
+	}
+	// the client IP is anonymized as in the log fields, and omitted if it is dropped
+	ip, _ := c.Anonymize(ClientIP(r, c.Proxy))
+	h.audit(r.Context(), "update", actor, ip, change)
+	return c, nil
+}
+
+// expireCaptures removes the captures that have expired from the config.
+func (h *AdminHandler) expireCaptures() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, _ := h.Holder.Load()
+	now := time.Now()
+	captures := make([]CaptureRule, 0, len(c.Captures))
+	var expired []CaptureRule
+	for _, rule := range c.Captures {
+		if !rule.Until.IsZero() && !now.Before(rule.Until) {
+			expired = append(expired, rule)
+		} else {
+			captures = append(captures, rule)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	c.Captures = captures
+	if err := h.Holder.Update(c); err == nil {
+		h.audit(context.Background(), "expire", "", "", expired)
+	}
+}
+
+func (h *AdminHandler) audit(ctx context.Context, action string, actor string, ip string, change interface{}) {
+	if h.send == nil {
+		return
+	}
+	fields := map[string]interface{}{"action": action, "change": change}
+	if len(actor) > 0 {
+		fields["actor"] = actor
+	}
+	if len(ip) > 0 {
+		fields["clientIp"] = ip
+	}
+	Send(ctx, h.send, "log config "+action, fields, h.KeyMap)
+}
+
+// RedactConfig returns a copy of c with its secrets replaced by "***".
+func RedactConfig(c LogConfig) LogConfig {
+	if c.JWT != nil && len(c.JWT.Secret) > 0 {
+		jwt := *c.JWT
+		jwt.Secret = DefaultRedaction
+		c.JWT = &jwt
+	}
+	if c.Debug != nil && len(c.Debug.Key) > 0 {
+		debug := *c.Debug
+		debug.Key = DefaultRedaction
+		c.Debug = &debug
+	}
+	if len(c.IpKey) > 0 {
+		c.IpKey = DefaultRedaction
+	}
+	if len(c.QueryKey) > 0 {
+		c.QueryKey = DefaultRedaction
+	}
+	return c
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
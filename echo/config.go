@@ -1,26 +1,55 @@
 package echo
 
 type LogConfig struct {
-	Separate       bool              `yaml:"separate" mapstructure:"separate" json:"separate,omitempty" gorm:"column:separate" bson:"separate,omitempty" dynamodbav:"separate,omitempty" firestore:"separate,omitempty"`
-	Build          bool              `yaml:"build" mapstructure:"build" json:"build,omitempty" gorm:"column:build" bson:"build,omitempty" dynamodbav:"build,omitempty" firestore:"build,omitempty"`
-	Json           bool              `yaml:"json" mapstructure:"json" json:"json,omitempty" gorm:"column:json" bson:"json,omitempty" dynamodbav:"json,omitempty" firestore:"json,omitempty"`
-	Log            bool              `yaml:"log" mapstructure:"log" json:"log,omitempty" gorm:"column:log" bson:"log,omitempty" dynamodbav:"log,omitempty" firestore:"log,omitempty"`
-	Skips          string            `yaml:"skips" mapstructure:"skips" json:"skips,omitempty" gorm:"column:skips" bson:"skips,omitempty" dynamodbav:"skips,omitempty" firestore:"skips,omitempty"`
-	Ip             string            `yaml:"ip" mapstructure:"ip" json:"ip,omitempty" gorm:"column:ip" bson:"ip,omitempty" dynamodbav:"ip,omitempty" firestore:"ip,omitempty"`
-	Duration       string            `yaml:"duration" mapstructure:"duration" json:"duration,omitempty" gorm:"column:duration" bson:"duration,omitempty" dynamodbav:"duration,omitempty" firestore:"duration,omitempty"`
-	Uri            string            `yaml:"uri" mapstructure:"uri" json:"uri,omitempty" gorm:"column:uri" bson:"uri,omitempty" dynamodbav:"uri,omitempty" firestore:"uri,omitempty"`
-	Body           string            `yaml:"body" mapstructure:"body" json:"body,omitempty" gorm:"column:body" bson:"body,omitempty" dynamodbav:"body,omitempty" firestore:"body,omitempty"`
-	Size           string            `yaml:"size" mapstructure:"size" json:"size,omitempty" gorm:"column:size" bson:"size,omitempty" dynamodbav:"size,omitempty" firestore:"size,omitempty"`
-	ReqId          string            `yaml:"req_id" mapstructure:"req_id" json:"reqId,omitempty" gorm:"column:reqid" bson:"reqId,omitempty" dynamodbav:"reqId,omitempty" firestore:"reqId,omitempty"`
-	Scheme         string            `yaml:"scheme" mapstructure:"scheme" json:"scheme,omitempty" gorm:"column:scheme" bson:"scheme,omitempty" dynamodbav:"scheme,omitempty" firestore:"scheme,omitempty"`
-	Proto          string            `yaml:"proto" mapstructure:"proto" json:"proto,omitempty" gorm:"column:proto" bson:"proto,omitempty" dynamodbav:"proto,omitempty" firestore:"proto,omitempty"`
-	Method         string            `yaml:"method" mapstructure:"method" json:"method,omitempty" gorm:"column:method" bson:"method,omitempty" dynamodbav:"method,omitempty" firestore:"method,omitempty"`
-	RemoteAddr     string            `yaml:"remote_addr" mapstructure:"remote_addr" json:"remoteAddr,omitempty" gorm:"column:remoteAddr" bson:"remoteAddr,omitempty" dynamodbav:"remoteAddr,omitempty" firestore:"remoteAddr,omitempty"`
-	RemoteIp       string            `yaml:"remote_ip" mapstructure:"remote_ip" json:"remoteIp,omitempty" gorm:"column:remoteIp" bson:"remoteIp,omitempty" dynamodbav:"remoteIp,omitempty" firestore:"remoteIp,omitempty"`
+	Separate       bool          `yaml:"separate" mapstructure:"separate" json:"separate,omitempty" gorm:"column:separate" bson:"separate,omitempty" dynamodbav:"separate,omitempty" firestore:"separate,omitempty"`
+	Build          bool          `yaml:"build" mapstructure:"build" json:"build,omitempty" gorm:"column:build" bson:"build,omitempty" dynamodbav:"build,omitempty" firestore:"build,omitempty"`
+	Json           bool          `yaml:"json" mapstructure:"json" json:"json,omitempty" gorm:"column:json" bson:"json,omitempty" dynamodbav:"json,omitempty" firestore:"json,omitempty"`
+	Log            bool          `yaml:"log" mapstructure:"log" json:"log,omitempty" gorm:"column:log" bson:"log,omitempty" dynamodbav:"log,omitempty" firestore:"log,omitempty"`
+	Skips          string        `yaml:"skips" mapstructure:"skips" json:"skips,omitempty" gorm:"column:skips" bson:"skips,omitempty" dynamodbav:"skips,omitempty" firestore:"skips,omitempty"`
+	SkipRules      []PathPattern `yaml:"skip_rules" mapstructure:"skip_rules" json:"skipRules,omitempty" gorm:"column:skiprules" bson:"skipRules,omitempty" dynamodbav:"skipRules,omitempty" firestore:"skipRules,omitempty"`
+	Ip             string        `yaml:"ip" mapstructure:"ip" json:"ip,omitempty" gorm:"column:ip" bson:"ip,omitempty" dynamodbav:"ip,omitempty" firestore:"ip,omitempty"`
+	Duration       string        `yaml:"duration" mapstructure:"duration" json:"duration,omitempty" gorm:"column:duration" bson:"duration,omitempty" dynamodbav:"duration,omitempty" firestore:"duration,omitempty"`
+	DurationUnit   string        `yaml:"duration_unit" mapstructure:"duration_unit" json:"durationUnit,omitempty" gorm:"column:durationunit" bson:"durationUnit,omitempty" dynamodbav:"durationUnit,omitempty" firestore:"durationUnit,omitempty"`
+	ReadTime       string        `yaml:"read_time" mapstructure:"read_time" json:"readTime,omitempty" gorm:"column:readtime" bson:"readTime,omitempty" dynamodbav:"readTime,omitempty" firestore:"readTime,omitempty"`
+	FirstByte      string        `yaml:"first_byte" mapstructure:"first_byte" json:"firstByte,omitempty" gorm:"column:firstbyte" bson:"firstByte,omitempty" dynamodbav:"firstByte,omitempty" firestore:"firstByte,omitempty"`
+	LastByte       string        `yaml:"last_byte" mapstructure:"last_byte" json:"lastByte,omitempty" gorm:"column:lastbyte" bson:"lastByte,omitempty" dynamodbav:"lastByte,omitempty" firestore:"lastByte,omitempty"`
+	ServerTiming   string        `yaml:"server_timing" mapstructure:"server_timing" json:"serverTiming,omitempty" gorm:"column:servertiming" bson:"serverTiming,omitempty" dynamodbav:"serverTiming,omitempty" firestore:"serverTiming,omitempty"`
+	Uri            string        `yaml:"uri" mapstructure:"uri" json:"uri,omitempty" gorm:"column:uri" bson:"uri,omitempty" dynamodbav:"uri,omitempty" firestore:"uri,omitempty"`
+	Route          string        `yaml:"route" mapstructure:"route" json:"route,omitempty" gorm:"column:route" bson:"route,omitempty" dynamodbav:"route,omitempty" firestore:"route,omitempty"`
+	Body           string        `yaml:"body" mapstructure:"body" json:"body,omitempty" gorm:"column:body" bson:"body,omitempty" dynamodbav:"body,omitempty" firestore:"body,omitempty"`
+	Size           string        `yaml:"size" mapstructure:"size" json:"size,omitempty" gorm:"column:size" bson:"size,omitempty" dynamodbav:"size,omitempty" firestore:"size,omitempty"`
+	ReqId          string        `yaml:"req_id" mapstructure:"req_id" json:"reqId,omitempty" gorm:"column:reqid" bson:"reqId,omitempty" dynamodbav:"reqId,omitempty" firestore:"reqId,omitempty"`
+	TraceId        string        `yaml:"trace_id" mapstructure:"trace_id" json:"traceId,omitempty" gorm:"column:traceid" bson:"traceId,omitempty" dynamodbav:"traceId,omitempty" firestore:"traceId,omitempty"`
+	SpanId         string        `yaml:"span_id" mapstructure:"span_id" json:"spanId,omitempty" gorm:"column:spanid" bson:"spanId,omitempty" dynamodbav:"spanId,omitempty" firestore:"spanId,omitempty"`
+	TraceFlags     string        `yaml:"trace_flags" mapstructure:"trace_flags" json:"traceFlags,omitempty" gorm:"column:traceflags" bson:"traceFlags,omitempty" dynamodbav:"traceFlags,omitempty" firestore:"traceFlags,omitempty"`
+	Scheme         string        `yaml:"scheme" mapstructure:"scheme" json:"scheme,omitempty" gorm:"column:scheme" bson:"scheme,omitempty" dynamodbav:"scheme,omitempty" firestore:"scheme,omitempty"`
+	Proto          string        `yaml:"proto" mapstructure:"proto" json:"proto,omitempty" gorm:"column:proto" bson:"proto,omitempty" dynamodbav:"proto,omitempty" firestore:"proto,omitempty"`
+	Method         string        `yaml:"method" mapstructure:"method" json:"method,omitempty" gorm:"column:method" bson:"method,omitempty" dynamodbav:"method,omitempty" firestore:"method,omitempty"`
+	RemoteAddr     string        `yaml:"remote_addr" mapstructure:"remote_addr" json:"remoteAddr,omitempty" gorm:"column:remoteAddr" bson:"remoteAddr,omitempty" dynamodbav:"remoteAddr,omitempty" firestore:"remoteAddr,omitempty"`
+	RemoteIp       string        `yaml:"remote_ip" mapstructure:"remote_ip" json:"remoteIp,omitempty" gorm:"column:remoteIp" bson:"remoteIp,omitempty" dynamodbav:"remoteIp,omitempty" firestore:"remoteIp,omitempty"`
+	IpConfig       `yaml:",inline" mapstructure:",squash"`
 	UserAgent      string            `yaml:"user_agent" mapstructure:"user_agent" json:"userAgent,omitempty" gorm:"column:userAgent" bson:"userAgent,omitempty" dynamodbav:"userAgent,omitempty" firestore:"userAgent,omitempty"`
 	ResponseStatus string            `yaml:"status" mapstructure:"status" json:"status,omitempty" gorm:"column:status" bson:"status,omitempty" dynamodbav:"status,omitempty" firestore:"status,omitempty"`
 	Request        string            `yaml:"request" mapstructure:"request" json:"request,omitempty" gorm:"column:request" bson:"request,omitempty" dynamodbav:"request,omitempty" firestore:"request,omitempty"`
 	Response       string            `yaml:"response" mapstructure:"response" json:"response,omitempty" gorm:"column:response" bson:"response,omitempty" dynamodbav:"response,omitempty" firestore:"response,omitempty"`
+	RequestLimit   int               `yaml:"request_limit" mapstructure:"request_limit" json:"requestLimit,omitempty" gorm:"column:requestlimit" bson:"requestLimit,omitempty" dynamodbav:"requestLimit,omitempty" firestore:"requestLimit,omitempty"`
+	ResponseLimit  int               `yaml:"response_limit" mapstructure:"response_limit" json:"responseLimit,omitempty" gorm:"column:responselimit" bson:"responseLimit,omitempty" dynamodbav:"responseLimit,omitempty" firestore:"responseLimit,omitempty"`
+	BodyMethods    string            `yaml:"body_methods" mapstructure:"body_methods" json:"bodyMethods,omitempty" gorm:"column:bodymethods" bson:"bodyMethods,omitempty" dynamodbav:"bodyMethods,omitempty" firestore:"bodyMethods,omitempty"`
+	BodyTypes      string            `yaml:"body_types" mapstructure:"body_types" json:"bodyTypes,omitempty" gorm:"column:bodytypes" bson:"bodyTypes,omitempty" dynamodbav:"bodyTypes,omitempty" firestore:"bodyTypes,omitempty"`
+	BodyHash       bool              `yaml:"body_hash" mapstructure:"body_hash" json:"bodyHash,omitempty" gorm:"column:bodyhash" bson:"bodyHash,omitempty" dynamodbav:"bodyHash,omitempty" firestore:"bodyHash,omitempty"`
+	Deferred       *DeferredConfig   `yaml:"deferred" mapstructure:"deferred" json:"deferred,omitempty" gorm:"column:deferred" bson:"deferred,omitempty" dynamodbav:"deferred,omitempty" firestore:"deferred,omitempty"`
+	Sampling       *SamplingConfig   `yaml:"sampling" mapstructure:"sampling" json:"sampling,omitempty" gorm:"column:sampling" bson:"sampling,omitempty" dynamodbav:"sampling,omitempty" firestore:"sampling,omitempty"`
+	Async          *AsyncConfig      `yaml:"async" mapstructure:"async" json:"async,omitempty" gorm:"column:async" bson:"async,omitempty" dynamodbav:"async,omitempty" firestore:"async,omitempty"`
+	Levels         *LevelConfig      `yaml:"levels" mapstructure:"levels" json:"levels,omitempty" gorm:"column:levels" bson:"levels,omitempty" dynamodbav:"levels,omitempty" firestore:"levels,omitempty"`
+	Slow           *SlowConfig       `yaml:"slow" mapstructure:"slow" json:"slow,omitempty" gorm:"column:slow" bson:"slow,omitempty" dynamodbav:"slow,omitempty" firestore:"slow,omitempty"`
+	JWT            *JWTConfig        `yaml:"jwt" mapstructure:"jwt" json:"jwt,omitempty" gorm:"column:jwt" bson:"jwt,omitempty" dynamodbav:"jwt,omitempty" firestore:"jwt,omitempty"`
+	Proxy          *ProxyConfig      `yaml:"proxy" mapstructure:"proxy" json:"proxy,omitempty" gorm:"column:proxy" bson:"proxy,omitempty" dynamodbav:"proxy,omitempty" firestore:"proxy,omitempty"`
+	Captures       []CaptureRule     `yaml:"captures" mapstructure:"captures" json:"captures,omitempty" gorm:"column:captures" bson:"captures,omitempty" dynamodbav:"captures,omitempty" firestore:"captures,omitempty"`
+	Debug          *DebugConfig      `yaml:"debug" mapstructure:"debug" json:"debug,omitempty" gorm:"column:debug" bson:"debug,omitempty" dynamodbav:"debug,omitempty" firestore:"debug,omitempty"`
+	LogHeaders     *HeaderConfig     `yaml:"log_headers" mapstructure:"log_headers" json:"logHeaders,omitempty" gorm:"column:logheaders" bson:"logHeaders,omitempty" dynamodbav:"logHeaders,omitempty" firestore:"logHeaders,omitempty"`
+	Query          string            `yaml:"query" mapstructure:"query" json:"query,omitempty" gorm:"column:query" bson:"query,omitempty" dynamodbav:"query,omitempty" firestore:"query,omitempty"`
+	QueryRules     map[string]string `yaml:"query_rules" mapstructure:"query_rules" json:"queryRules,omitempty" gorm:"column:queryrules" bson:"queryRules,omitempty" dynamodbav:"queryRules,omitempty" firestore:"queryRules,omitempty"`
+	QueryKey       string            `yaml:"query_key" mapstructure:"query_key" json:"queryKey,omitempty" gorm:"column:querykey" bson:"queryKey,omitempty" dynamodbav:"queryKey,omitempty" firestore:"queryKey,omitempty"`
 	Fields         string            `yaml:"fields" mapstructure:"fields" json:"fields,omitempty" gorm:"column:fields" bson:"fields,omitempty" dynamodbav:"fields,omitempty" firestore:"fields,omitempty"`
 	Masks          string            `yaml:"masks" mapstructure:"masks" json:"masks,omitempty" gorm:"column:masks" bson:"masks,omitempty" dynamodbav:"masks,omitempty" firestore:"masks,omitempty"`
 	Map            map[string]string `yaml:"map" mapstructure:"map" json:"map,omitempty" gorm:"column:map" bson:"map,omitempty" dynamodbav:"map,omitempty" firestore:"map,omitempty"`
@@ -29,13 +58,24 @@ type LogConfig struct {
 }
 
 type FieldConfig struct {
-	Log       bool              `yaml:"log" mapstructure:"log" json:"log,omitempty" gorm:"column:log" bson:"log,omitempty" dynamodbav:"log,omitempty" firestore:"log,omitempty"`
-	Ip        string            `yaml:"ip" mapstructure:"ip" json:"ip,omitempty" gorm:"column:ip" bson:"ip,omitempty" dynamodbav:"ip,omitempty" firestore:"ip,omitempty"`
-	Map       map[string]string `yaml:"map" mapstructure:"map" json:"map,omitempty" gorm:"column:map" bson:"map,omitempty" dynamodbav:"map,omitempty" firestore:"map,omitempty"`
-	Constants map[string]string `yaml:"constants" mapstructure:"constants" json:"constants,omitempty" gorm:"column:constants" bson:"constants,omitempty" dynamodbav:"constants,omitempty" firestore:"constants,omitempty"`
-	Duration  string            `yaml:"duration" mapstructure:"duration" json:"duration,omitempty" gorm:"column:duration" bson:"duration,omitempty" dynamodbav:"duration,omitempty" firestore:"duration,omitempty"`
-	Fields    []string          `yaml:"fields" mapstructure:"fields" json:"fields,omitempty" gorm:"column:fields" bson:"fields,omitempty" dynamodbav:"fields,omitempty" firestore:"fields,omitempty"`
-	Masks     []string          `yaml:"masks" mapstructure:"masks" json:"masks,omitempty" gorm:"column:masks" bson:"masks,omitempty" dynamodbav:"masks,omitempty" firestore:"masks,omitempty"`
-	Skips     []string          `yaml:"skips" mapstructure:"skips" json:"skips,omitempty" gorm:"column:skips" bson:"skips,omitempty" dynamodbav:"skips,omitempty" firestore:"skips,omitempty"`
-	Headers   map[string]string `yaml:"headers" mapstructure:"headers" json:"headers,omitempty" gorm:"column:headers" bson:"headers,omitempty" dynamodbav:"headers,omitempty" firestore:"headers,omitempty"`
+	Log         bool   `yaml:"log" mapstructure:"log" json:"log,omitempty" gorm:"column:log" bson:"log,omitempty" dynamodbav:"log,omitempty" firestore:"log,omitempty"`
+	Ip          string `yaml:"ip" mapstructure:"ip" json:"ip,omitempty" gorm:"column:ip" bson:"ip,omitempty" dynamodbav:"ip,omitempty" firestore:"ip,omitempty"`
+	IpConfig    `yaml:",inline" mapstructure:",squash"`
+	Map         map[string]string `yaml:"map" mapstructure:"map" json:"map,omitempty" gorm:"column:map" bson:"map,omitempty" dynamodbav:"map,omitempty" firestore:"map,omitempty"`
+	Constants   map[string]string `yaml:"constants" mapstructure:"constants" json:"constants,omitempty" gorm:"column:constants" bson:"constants,omitempty" dynamodbav:"constants,omitempty" firestore:"constants,omitempty"`
+	Duration    string            `yaml:"duration" mapstructure:"duration" json:"duration,omitempty" gorm:"column:duration" bson:"duration,omitempty" dynamodbav:"duration,omitempty" firestore:"duration,omitempty"`
+	Fields      []string          `yaml:"fields" mapstructure:"fields" json:"fields,omitempty" gorm:"column:fields" bson:"fields,omitempty" dynamodbav:"fields,omitempty" firestore:"fields,omitempty"`
+	Masks       []string          `yaml:"masks" mapstructure:"masks" json:"masks,omitempty" gorm:"column:masks" bson:"masks,omitempty" dynamodbav:"masks,omitempty" firestore:"masks,omitempty"`
+	Skips       []string          `yaml:"skips" mapstructure:"skips" json:"skips,omitempty" gorm:"column:skips" bson:"skips,omitempty" dynamodbav:"skips,omitempty" firestore:"skips,omitempty"`
+	Headers     map[string]string `yaml:"headers" mapstructure:"headers" json:"headers,omitempty" gorm:"column:headers" bson:"headers,omitempty" dynamodbav:"headers,omitempty" firestore:"headers,omitempty"`
+	BodyMethods []string          `yaml:"body_methods" mapstructure:"body_methods" json:"bodyMethods,omitempty" gorm:"column:bodymethods" bson:"bodyMethods,omitempty" dynamodbav:"bodyMethods,omitempty" firestore:"bodyMethods,omitempty"`
+	BodyTypes   []string          `yaml:"body_types" mapstructure:"body_types" json:"bodyTypes,omitempty" gorm:"column:bodytypes" bson:"bodyTypes,omitempty" dynamodbav:"bodyTypes,omitempty" firestore:"bodyTypes,omitempty"`
+	SkipRules   []PathPattern     `yaml:"skip_rules" mapstructure:"skip_rules" json:"skipRules,omitempty" gorm:"column:skiprules" bson:"skipRules,omitempty" dynamodbav:"skipRules,omitempty" firestore:"skipRules,omitempty"`
+	Sampling    *SamplingConfig   `yaml:"sampling" mapstructure:"sampling" json:"sampling,omitempty" gorm:"column:sampling" bson:"sampling,omitempty" dynamodbav:"sampling,omitempty" firestore:"sampling,omitempty"`
+	Slow        *SlowConfig       `yaml:"slow" mapstructure:"slow" json:"slow,omitempty" gorm:"column:slow" bson:"slow,omitempty" dynamodbav:"slow,omitempty" firestore:"slow,omitempty"`
+	Deferred    *DeferredConfig   `yaml:"deferred" mapstructure:"deferred" json:"deferred,omitempty" gorm:"column:deferred" bson:"deferred,omitempty" dynamodbav:"deferred,omitempty" firestore:"deferred,omitempty"`
+	JWT         *JWTConfig        `yaml:"jwt" mapstructure:"jwt" json:"jwt,omitempty" gorm:"column:jwt" bson:"jwt,omitempty" dynamodbav:"jwt,omitempty" firestore:"jwt,omitempty"`
+	Proxy       *ProxyConfig      `yaml:"proxy" mapstructure:"proxy" json:"proxy,omitempty" gorm:"column:proxy" bson:"proxy,omitempty" dynamodbav:"proxy,omitempty" firestore:"proxy,omitempty"`
+	Captures    []CaptureRule     `yaml:"captures" mapstructure:"captures" json:"captures,omitempty" gorm:"column:captures" bson:"captures,omitempty" dynamodbav:"captures,omitempty" firestore:"captures,omitempty"`
+	Debug       *DebugConfig      `yaml:"debug" mapstructure:"debug" json:"debug,omitempty" gorm:"column:debug" bson:"debug,omitempty" dynamodbav:"debug,omitempty" firestore:"debug,omitempty"`
 }
@@ -0,0 +1,143 @@
+package echo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+type ctxKeyLevel int
+
+// LevelKey is the key that holds the level of a request log in the context passed to the Formatter.
+const LevelKey ctxKeyLevel = 0
+
+// GetLevel returns the level of a request log from the given context, or "info" if there is none.
+func GetLevel(ctx context.Context) string {
+	if ctx == nil {
+		return LevelInfo
+	}
+	if level, ok := ctx.Value(LevelKey).(string); ok && len(level) > 0 {
+		return level
+	}
+	return LevelInfo
+}
+
+// LevelConfig maps a response to a level. Status maps status codes such as "404" or classes such as "5xx" to levels,
+// 4xx to "warn" and 5xx to "error" if it is empty. A request slower than Slow milliseconds is logged at least at SlowLevel,
+// and a request whose handler panics is logged at Panic.
+type LevelConfig struct {
+	Default   string            `yaml:"default" mapstructure:"default" json:"default,omitempty" gorm:"column:default" bson:"default,omitempty" dynamodbav:"default,omitempty" firestore:"default,omitempty"`
+	Status    map[string]string `yaml:"status" mapstructure:"status" json:"status,omitempty" gorm:"column:status" bson:"status,omitempty" dynamodbav:"status,omitempty" firestore:"status,omitempty"`
+	Slow      int64             `yaml:"slow" mapstructure:"slow" json:"slow,omitempty" gorm:"column:slow" bson:"slow,omitempty" dynamodbav:"slow,omitempty" firestore:"slow,omitempty"`
+	SlowLevel string            `yaml:"slow_level" mapstructure:"slow_level" json:"slowLevel,omitempty" gorm:"column:slowlevel" bson:"slowLevel,omitempty" dynamodbav:"slowLevel,omitempty" firestore:"slowLevel,omitempty"`
+	Panic     string            `yaml:"panic" mapstructure:"panic" json:"panic,omitempty" gorm:"column:panic" bson:"panic,omitempty" dynamodbav:"panic,omitempty" firestore:"panic,omitempty"`
+}
+
+// LevelLogger holds a log function per level. A missing function falls back to the info log function of the logger.
+type LevelLogger struct {
+	Debug func(ctx context.Context, msg string, fields map[string]interface{})
+	Info  func(ctx context.Context, msg string, fields map[string]interface{})
+	Warn  func(ctx context.Context, msg string, fields map[string]interface{})
+	Error func(ctx context.Context, msg string, fields map[string]interface{})
+}
+
+// Func returns the log function of level, or nil if there is none.
+func (l LevelLogger) Func(level string) func(ctx context.Context, msg string, fields map[string]interface{}) {
+	switch level {
+	case LevelDebug:
+		return l.Debug
+	case LevelInfo:
+		return l.Info
+	case LevelWarn:
+		return l.Warn
+	case LevelError:
+		return l.Error
+	default:
+		return nil
+	}
+}
+
+var levelRanks = map[string]int{LevelDebug: 1, LevelInfo: 2, LevelWarn: 3, LevelError: 4}
+
+// MaxLevel returns the more severe of two levels.
+func MaxLevel(a string, b string) string {
+	if levelRanks[b] > levelRanks[a] {
+		return b
+	}
+	return a
+}
+
+// ValidateLevelConfig returns an error if a level of c is not "debug", "info", "warn" or "error".
+func ValidateLevelConfig(c *LevelConfig) error {
+	if c == nil {
+		return nil
+	}
+	levels := []string{c.Default, c.SlowLevel, c.Panic}
+	for _, level := range c.Status {
+		levels = append(levels, level)
+	}
+	for _, level := range levels {
+		if _, ok := levelRanks[level]; len(level) > 0 && !ok {
+			return fmt.Errorf("invalid level %q", level)
+		}
+	}
+	return nil
+}
+
+// RequestLevel returns the level of a request logged before its response.
+func RequestLevel(c *LevelConfig) string {
+	if c == nil || len(c.Default) == 0 {
+		return LevelInfo
+	}
+	return c.Default
+}
+
+// ResponseLevel returns the level of a response log. Without config, all responses are logged at "info".
+func ResponseLevel(c *LevelConfig, status int, duration time.Duration, panicked bool) string {
+	if c == nil {
+		return LevelInfo
+	}
+	if panicked {
+		if len(c.Panic) > 0 {
+			return c.Panic
+		}
+		return LevelError
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	level := RequestLevel(c)
+	if len(c.Status) == 0 {
+		if status >= 500 {
+			level = LevelError
+		} else if status >= 400 {
+			level = LevelWarn
+		}
+	} else if l, ok := c.Status[strconv.Itoa(status)]; ok {
+		level = l
+	} else if l, ok := c.Status[strconv.Itoa(status/100)+"xx"]; ok {
+		level = l
+	}
+	if c.Slow > 0 && duration.Milliseconds() > c.Slow {
+		slowLevel := c.SlowLevel
+		if len(slowLevel) == 0 {
+			slowLevel = LevelWarn
+		}
+		level = MaxLevel(level, slowLevel)
+	}
+	return level
+}
+
+// WithLevel returns a shallow copy of r whose context holds level under LevelKey.
+func WithLevel(r *http.Request, level string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), LevelKey, level))
+}
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"github.com/labstack/echo/v4"
 	"io"
+	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,54 +19,240 @@ type EchoLogger struct {
 	LogInfo func(ctx context.Context, msg string, fields map[string]interface{})
 	f       Formatter
 	Mask    func(fieldName, s string) string
+	// Levels are the log functions of the levels of LogConfig.Levels, LogInfo is used for the missing ones.
+	Levels LevelLogger
+
+	// Holder, if set, replaces Config: the loggers reload it on each request.
+	Holder      *ConfigHolder
+	fieldConfig *FieldConfig
+	debugErrors int64
+	queue       *LogQueue
 }
 
 func NewEchoLogger(c LogConfig, logInfo func(ctx context.Context, msg string, fields map[string]interface{}), f Formatter, mask func(fieldName, s string) string) *EchoLogger {
-	return &EchoLogger{c, logInfo, f, mask}
+	fc := NewFieldConfig(c)
+	// the log fields resolve the client IP with the compiled trusted proxies
+	c.Proxy = fc.Proxy
+	l := &EchoLogger{Config: c, LogInfo: logInfo, f: f, Mask: mask, fieldConfig: &fc}
+	if c.Async != nil {
+		l.queue = NewLogQueue(*c.Async)
+	}
+	return l
+}
+
+func NewEchoLoggerWithLevels(c LogConfig, logs LevelLogger, f Formatter, mask func(fieldName, s string) string) *EchoLogger {
+	l := NewEchoLogger(c, logs.Info, f, mask)
+	l.Levels = logs
+	return l
+}
+
+// getFieldConfig compiles Config on first use, for loggers not created by NewEchoLogger.
+func (l *EchoLogger) getFieldConfig() *FieldConfig {
+	if l.fieldConfig == nil {
+		fc := NewFieldConfig(l.Config)
+		l.fieldConfig = &fc
+	}
+	return l.fieldConfig
+}
+
+// load returns the config of a request: the current config of Holder if it is set.
+func (l *EchoLogger) load() (LogConfig, *FieldConfig) {
+	if l.Holder != nil {
+		return l.Holder.Load()
+	}
+	return l.Config, l.fieldConfig
+}
+
+// debug applies the debug token of r, counting the invalid ones.
+func (l *EchoLogger) debug(r *http.Request, c LogConfig, fc *FieldConfig) (*http.Request, LogConfig, *FieldConfig) {
+	dr, dc, dfc, err := DebugRequest(r, c, fc)
+	if err != nil {
+		atomic.AddInt64(&l.debugErrors, 1)
+	}
+	return dr, dc, dfc
+}
+
+// DebugErrors returns the number of invalid or expired debug tokens received.
+func (l *EchoLogger) DebugErrors() int64 {
+	return atomic.LoadInt64(&l.debugErrors)
+}
+
+func (l *EchoLogger) logFunc(level string) func(ctx context.Context, msg string, fields map[string]interface{}) {
+	if fn := l.Levels.Func(level); fn != nil {
+		return fn
+	}
+	return l.LogInfo
+}
+
+func (l *EchoLogger) getQueue() *LogQueue {
+	if l.queue == nil && l.Config.Async != nil {
+		l.queue = NewLogQueue(*l.Config.Async)
+	}
+	return l.queue
+}
+
+// Flush waits until the queued records are written, if Config.Async is set.
+func (l *EchoLogger) Flush(ctx context.Context) error {
+	if l.queue == nil {
+		return nil
+	}
+	return l.queue.Flush(ctx)
+}
+
+// Shutdown drains the queued records and stops the workers, if Config.Async is set.
+func (l *EchoLogger) Shutdown(ctx context.Context) error {
+	if l.queue == nil {
+		return nil
+	}
+	return l.queue.Shutdown(ctx)
+}
+
+// Dropped returns the number of records dropped by the queue, if Config.Async is set.
+func (l *EchoLogger) Dropped() int64 {
+	if l.queue == nil {
+		return 0
+	}
+	return l.queue.Dropped()
+}
+
+// Pending returns the number of records queued or being written, if Config.Async is set.
+func (l *EchoLogger) Pending() int64 {
+	if l.queue == nil {
+		return 0
+	}
+	return l.queue.Pending()
 }
 
 func (l *EchoLogger) Logger(next echo.HandlerFunc) echo.HandlerFunc {
-	InitializeFieldConfig(l.Config)
+	// compile Config before serving concurrent requests
+	l.getFieldConfig()
+	q := l.getQueue()
 	return func(c echo.Context) error {
-		if !fieldConfig.Log || InSkipList(c.Request(), fieldConfig.Skips) {
+		lc, fc := l.load()
+		if route := c.Path(); len(route) > 0 {
+			c.SetRequest(WithRoute(c.Request(), route))
+		}
+		lc, fc = CaptureBodies(c.Request(), lc, fc)
+		if fc.Debug != nil {
+			var r *http.Request
+			r, lc, fc = l.debug(c.Request(), lc, fc)
+			c.SetRequest(r)
+		}
+		if !fc.Log || InSkipList(c.Request(), fc.Skips) || InSkipRules(c.Request(), fc.SkipRules) {
 			return next(c)
 		} else {
 			r := c.Request()
-			dw := NewResponseWriter(c.Response().Writer)
+			dw := NewResponseWriter(c.Response().Writer, DeferredLimit(lc.ResponseLimit, fc.Deferred))
+			dw.Hash = newHash(lc)
 			ww := NewWrapResponseWriter(dw, r.ProtoMajor)
 			startTime := time.Now()
-			fields := BuildLogFields(l.Config, r)
-			includeRequest := !l.Config.Separate
-			if r.Method == "GET" || r.Method == "DELETE" || strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
+			fields := BuildLogFieldsWithMask(lc, r, l.Mask)
+			rate, sampled, byTrace := 1.0, true, false
+			if fc.Sampling != nil {
+				rate, sampled, byTrace = HeadSample(r, fc.Sampling)
+				BuildSampleFields(fc.Sampling, rate, byTrace, fields)
+			}
+			includeRequest := !lc.Separate || !sampled || fc.Deferred != nil
+			readStart := time.Now()
+			var slowBody *SlowBody
+			if !CaptureMethod(r.Method, fc.BodyMethods) {
 				includeRequest = true
+				slowBody = CaptureSlowRequest(r, fc.Slow, fc.BodyTypes)
+			} else if CaptureRequestType(r.Header.Get("Content-Type"), fc.BodyTypes) {
+				BuildRequestBodyWithLimit(r, lc.Request, DeferredLimit(lc.RequestLimit, fc.Deferred), fields)
+				if len(lc.Request) == 0 {
+					slowBody = CaptureSlowRequest(r, fc.Slow, fc.BodyTypes)
+				}
 			} else {
-				BuildRequest(r, l.Config.Request, fields)
+				includeRequest = true
+				BuildBinaryRequestBody(r, lc.Request, newHash(lc), fields)
+			}
+			read := time.Since(readStart)
+			if len(lc.ReadTime) > 0 {
+				TimeRequestBody(r)
 			}
 			if !includeRequest {
-				go l.f.LogRequest(l.LogInfo, r, fields)
+				level := RequestLevel(lc.Levels)
+				log, lr := l.logFunc(level), BuildLogRequest(r, lc, level, l.Mask)
+				Dispatch(q, func() {
+					l.f.LogRequest(log, lr, fields)
+				})
 			}
 			c.Response().Writer = ww
+			completed := false
+			var err error
 			defer func() {
+				elapsed := time.Since(startTime)
+				slow := IsSlow(r, fc.Slow, elapsed)
+				if fc.Sampling != nil {
+					if slow || TailSample(fc.Sampling, ww.Status(), elapsed) {
+						rate, byTrace = 1, false
+					} else if !sampled {
+						return
+					}
+				}
+				resFields := fields
 				if includeRequest {
-					go l.f.LogResponse(l.LogInfo, r, ww, l.Config, startTime, dw.Body.String(), fields, includeRequest)
+					UpdateRequestSize(r, lc.Request, fields)
 				} else {
-					resFields := BuildLogFields(l.Config, r)
-					go l.f.LogResponse(l.LogInfo, r, ww, l.Config, startTime, dw.Body.String(), resFields, includeRequest)
+					resFields = BuildLogFieldsWithMask(lc, r, l.Mask)
+				}
+				if fc.Sampling != nil {
+					BuildSampleFields(fc.Sampling, rate, byTrace, resFields)
+				}
+				BuildTimingFields(lc, r, startTime, read, dw.Timing, resFields)
+				BuildRouteField(lc, GetRoute(r), resFields)
+				BuildServerTimingFields(lc, r, resFields)
+				rc, response := lc, dw.Body.String()
+				if slow {
+					rc, response = EscalateSlow(lc, fc.Slow, r, slowBody, response, resFields)
+				}
+				if fc.Deferred != nil {
+					if KeepBodies(fc.Deferred, ww.Status(), !completed || err != nil) {
+						rc.ResponseLimit = DeferredLimit(rc.ResponseLimit, fc.Deferred)
+					} else {
+						rc = DiscardBodies(rc, resFields)
+					}
+				}
+				contentType := ResponseContentType(ww.Header(), dw.Body.Bytes())
+				rc = BuildBinaryResponseBody(rc, fc.BodyTypes, contentType, ww.BytesWritten(), dw.Hash, resFields)
+				level := ResponseLevel(lc.Levels, ww.Status(), elapsed, !completed)
+				if slow {
+					level = MaxLevel(level, fc.Slow.Level)
 				}
+				log, lr := l.logFunc(level), BuildLogRequest(r, lc, level, l.Mask)
+				// shift startTime, so that the duration logged by the formatter excludes the time spent in the queue
+				Dispatch(q, func() {
+					l.f.LogResponse(log, lr, ww, rc, time.Now().Add(-elapsed), response, resFields, includeRequest || slow)
+				})
 			}()
-			return next(c)
+			err = next(c)
+			completed = true
+			if err != nil {
+				// let the error handler write the response now, so that its status is logged
+				c.Error(err)
+			}
+			// the error is handled, echo must not handle it again
+			return nil
 		}
-		return nil
 	}
 }
 
 func (l *EchoLogger) BuildContextWithMask(next echo.HandlerFunc) echo.HandlerFunc {
+	// compile Config before serving concurrent requests
+	l.getFieldConfig()
 	return func(c echo.Context) error {
+		_, fc := l.load()
 		ctxEcho := c
 		var ctx context.Context
 		ctx = c.Request().Context()
-		if fieldConfig.Constants != nil && len(fieldConfig.Constants) > 0 {
-			for k, e := range fieldConfig.Constants {
+		if len(fc.Ip) > 0 {
+			if ip, ok := fc.Anonymize(ClientIP(c.Request(), fc.Proxy)); ok {
+				ctx = context.WithValue(ctx, fc.Ip, ip)
+			}
+		}
+		if fc.Constants != nil && len(fc.Constants) > 0 {
+			for k, e := range fc.Constants {
 				if len(e) > 0 {
 					ctx = context.WithValue(ctx, k, e)
 				}
@@ -72,46 +260,46 @@ func (l *EchoLogger) BuildContextWithMask(next echo.HandlerFunc) echo.HandlerFun
 		}
 
 		r := c.Request()
-		if fieldConfig.Headers != nil && len(fieldConfig.Headers) > 0 {
-			for k, e := range fieldConfig.Headers {
+		if fc.Headers != nil && len(fc.Headers) > 0 {
+			for k, e := range fc.Headers {
 				if len(e) > 0 {
 					header := r.Header.Get(e)
 					ctx = context.WithValue(ctx, k, header)
 				}
 			}
 		}
-		if fieldConfig.Map != nil && len(fieldConfig.Map) > 0 && r.Body != nil && (r.Method != "GET" || r.Method != "DELETE") {
+		if fc.Map != nil && len(fc.Map) > 0 && r.Body != nil && (r.Method != "GET" && r.Method != "DELETE") {
 			buf := new(bytes.Buffer)
 			buf.ReadFrom(r.Body)
 			r.Body = io.NopCloser(buf)
 			var v interface{}
 			er2 := json.NewDecoder(strings.NewReader(buf.String())).Decode(&v)
 			if er2 != nil {
-				if len(fieldConfig.Ip) == 0 && fieldConfig.Constants == nil {
-					next(c)
+				if len(fc.Ip) == 0 && fc.Constants == nil {
+					return next(c)
 				} else {
-					ctxEcho.Request().WithContext(ctx)
-					next(ctxEcho)
+					ctxEcho.SetRequest(ctxEcho.Request().WithContext(ctx))
+					return next(ctxEcho)
 				}
 			} else {
 				m, ok := v.(map[string]interface{})
 				if !ok {
-					if len(fieldConfig.Ip) == 0 && fieldConfig.Constants == nil {
+					if len(fc.Ip) == 0 && fc.Constants == nil {
 						return next(c)
 					} else {
-						ctxEcho.Request().WithContext(ctx)
+						ctxEcho.SetRequest(ctxEcho.Request().WithContext(ctx))
 						return next(ctxEcho)
 					}
 				} else {
-					for k, e := range fieldConfig.Map {
+					for k, e := range fc.Map {
 						if strings.Index(e, ".") >= 0 {
 							v3 := ValueOf(v, e)
 							if v3 != nil {
 								s3, ok3 := v3.(string)
 								if ok3 {
 									if len(s3) > 0 {
-										if l.Mask != nil && fieldConfig.Masks != nil && len(fieldConfig.Masks) > 0 {
-											if Include(fieldConfig.Masks, k) {
+										if l.Mask != nil && fc.Masks != nil && len(fc.Masks) > 0 {
+											if Include(fc.Masks, k) {
 												ctx = context.WithValue(ctx, k, l.Mask(k, s3))
 											} else {
 												ctx = context.WithValue(ctx, k, s3)
@@ -130,8 +318,8 @@ func (l *EchoLogger) BuildContextWithMask(next echo.HandlerFunc) echo.HandlerFun
 								s3, ok3 := x.(string)
 								if ok3 {
 									if len(s3) > 0 {
-										if l.Mask != nil && fieldConfig.Masks != nil && len(fieldConfig.Masks) > 0 {
-											if Include(fieldConfig.Masks, k) {
+										if l.Mask != nil && fc.Masks != nil && len(fc.Masks) > 0 {
+											if Include(fc.Masks, k) {
 												ctx = context.WithValue(ctx, k, l.Mask(k, s3))
 											} else {
 												ctx = context.WithValue(ctx, k, s3)
@@ -151,13 +339,12 @@ func (l *EchoLogger) BuildContextWithMask(next echo.HandlerFunc) echo.HandlerFun
 				}
 			}
 		} else {
-			if len(fieldConfig.Ip) == 0 && fieldConfig.Constants == nil && fieldConfig.Headers == nil {
+			if len(fc.Ip) == 0 && fc.Constants == nil && fc.Headers == nil {
 				return next(c)
 			} else {
 				ctxEcho.SetRequest(c.Request().WithContext(ctx))
 				return next(ctxEcho)
 			}
 		}
-		return nil
 	}
 }
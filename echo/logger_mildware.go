@@ -1,53 +1,161 @@
 package echo
 
 import (
+	"context"
+	"crypto/sha256"
+	"hash"
 	"net"
 	"net/http"
 	"strings"
 )
 
-func InitializeFieldConfig(c LogConfig) {
+// CompileFieldConfig compiles c into the FieldConfig used by a logger instance and its context builder.
+// It returns the first error reported by the Compile and Validate functions of the parts of c.
+func CompileFieldConfig(c LogConfig) (FieldConfig, error) {
+	var fc FieldConfig
 	if len(c.Duration) > 0 {
-		fieldConfig.Duration = c.Duration
+		fc.Duration = c.Duration
 	} else {
-		fieldConfig.Duration = "duration"
+		fc.Duration = "duration"
+	}
+	fc.Log = c.Log
+	fc.Ip = c.Ip
+	ipConfig, err := CompileIpConfig(c.IpConfig)
+	if err != nil {
+		return FieldConfig{}, err
 	}
-	fieldConfig.Log = c.Log
-	fieldConfig.Ip = c.Ip
+	fc.IpConfig = ipConfig
 	if c.Map != nil && len(c.Map) > 0 {
-		fieldConfig.Map = c.Map
+		fc.Map = c.Map
 	}
 	if c.Constants != nil && len(c.Constants) > 0 {
-		fieldConfig.Constants = c.Constants
+		fc.Constants = c.Constants
 	}
 	if c.Headers != nil && len(c.Headers) > 0 {
-		fieldConfig.Headers = c.Headers
+		fc.Headers = c.Headers
 	}
 	if len(c.Fields) > 0 {
 		fields := strings.Split(c.Fields, ",")
-		fieldConfig.Fields = fields
+		fc.Fields = fields
 	}
 	if len(c.Masks) > 0 {
 		fields := strings.Split(c.Masks, ",")
-		fieldConfig.Masks = fields
+		fc.Masks = fields
 	}
 	if len(c.Skips) > 0 {
 		fields := strings.Split(c.Skips, ",")
-		fieldConfig.Skips = fields
+		fc.Skips = fields
+	}
+	if len(c.BodyMethods) > 0 {
+		fields := strings.Split(c.BodyMethods, ",")
+		fc.BodyMethods = fields
+	}
+	if len(c.BodyTypes) > 0 {
+		fields := strings.Split(c.BodyTypes, ",")
+		fc.BodyTypes = fields
+	}
+	if len(c.SkipRules) > 0 {
+		rules, err := CompilePathPatterns(c.SkipRules)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.SkipRules = rules
+	}
+	if c.Sampling != nil {
+		sampling, err := CompileSamplingConfig(c.Sampling)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Sampling = sampling
+	}
+	if c.Slow != nil {
+		slow, err := CompileSlowConfig(c.Slow)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Slow = slow
+	}
+	if c.Deferred != nil {
+		deferred, err := CompileDeferredConfig(c.Deferred)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Deferred = deferred
+	}
+	if c.JWT != nil {
+		jwt, err := CompileJWTConfig(c.JWT)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.JWT = jwt
+	}
+	if c.Proxy != nil {
+		fc.Proxy = CompileProxyConfig(c.Proxy)
+	}
+	if len(c.Captures) > 0 {
+		captures, err := CompileCaptureRules(c.Captures)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Captures = captures
+	}
+	if c.Debug != nil {
+		debug, err := CompileDebugConfig(c.Debug)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Debug = debug
+	}
+	if c.Async != nil {
+		if err := ValidateAsyncConfig(*c.Async); err != nil {
+			return FieldConfig{}, err
+		}
+	}
+	if err := ValidateLevelConfig(c.Levels); err != nil {
+		return FieldConfig{}, err
+	}
+	if err := ValidateQueryRules(c.QueryRules, c.QueryKey); err != nil {
+		return FieldConfig{}, err
 	}
+	return fc, nil
 }
+
+// NewFieldConfig compiles c like CompileFieldConfig, and panics if c is invalid.
+func NewFieldConfig(c LogConfig) FieldConfig {
+	fc, err := CompileFieldConfig(c)
+	if err != nil {
+		panic(err)
+	}
+	return fc
+}
+
+// InitializeFieldConfig sets the package-level config used by BuildContext and BuildContextWithMask.
+func InitializeFieldConfig(c LogConfig) {
+	fieldConfig = NewFieldConfig(c)
+}
+
+// InSkipList reports whether the path of r, without query string, ends with one of skips.
 func InSkipList(r *http.Request, skips []string) bool {
 	if skips == nil || len(skips) == 0 {
 		return false
 	}
 	for _, s := range skips {
-		if strings.HasSuffix(s, r.RequestURI) {
+		s = strings.TrimSpace(s)
+		if len(s) > 0 && strings.HasSuffix(r.URL.Path, s) {
 			return true
 		}
 	}
 	return false
 }
+func InSkipRules(r *http.Request, rules []PathPattern) bool {
+	return MatchPathPatterns(r, rules) != nil
+}
 func BuildLogFields(c LogConfig, r *http.Request) map[string]interface{} {
+	return BuildLogFieldsWithMask(c, r, nil)
+}
+
+// BuildLogFieldsWithMask builds the request fields, applying c.QueryRules with mask to the uri and query fields.
+func BuildLogFieldsWithMask(c LogConfig, r *http.Request, mask func(fieldName, s string) string) map[string]interface{} {
 	fields := make(map[string]interface{}, 0)
 	if !c.Build {
 		return fields
@@ -57,7 +165,13 @@ func BuildLogFields(c LogConfig, r *http.Request) map[string]interface{} {
 		scheme = "https"
 	}
 	if len(c.Uri) > 0 {
-		fields[c.Uri] = r.RequestURI
+		fields[c.Uri] = MaskRequestURI(r.RequestURI, c.QueryRules, c.QueryKey, mask)
+	}
+	BuildRouteField(c, GetRoute(r), fields)
+	if len(c.Query) > 0 {
+		if query := BuildQuery(r.URL.RawQuery, c.QueryRules, c.QueryKey, mask); len(query) > 0 {
+			fields[c.Query] = query
+		}
 	}
 
 	if len(c.ReqId) > 0 {
@@ -65,6 +179,9 @@ func BuildLogFields(c LogConfig, r *http.Request) map[string]interface{} {
 			fields[c.ReqId] = reqID
 		}
 	}
+	BuildTraceFields(c, r, fields)
+	BuildClaimFields(c, r, fields)
+	BuildDebugField(c, r, fields)
 	if len(c.Scheme) > 0 {
 		fields[c.Scheme] = scheme
 	}
@@ -75,17 +192,52 @@ func BuildLogFields(c LogConfig, r *http.Request) map[string]interface{} {
 		fields[c.UserAgent] = r.UserAgent()
 	}
 	if len(c.RemoteAddr) > 0 {
-		fields[c.RemoteAddr] = r.RemoteAddr
+		if remoteAddr, ok := c.AnonymizeAddr(r.RemoteAddr); ok {
+			fields[c.RemoteAddr] = remoteAddr
+		}
 	}
 	if len(c.Method) > 0 {
 		fields[c.Method] = r.Method
 	}
 	if len(c.RemoteIp) > 0 {
-		remoteIP := getRemoteIp(r)
-		fields[c.RemoteIp] = remoteIP
+		if remoteIP, ok := c.Anonymize(ClientIP(r, c.Proxy)); ok {
+			fields[c.RemoteIp] = remoteIP
+		}
+	}
+	if c.LogHeaders != nil {
+		BuildHeaders(r.Header, c.LogHeaders.Request, c.LogHeaders, fields)
 	}
 	return fields
 }
+
+// BuildLogRequest returns the request passed to the Formatter: a shallow copy of r with level in its context
+// and c.QueryRules applied to its RequestURI, or r itself if neither is needed.
+func BuildLogRequest(r *http.Request, c LogConfig, level string, mask func(fieldName, s string) string) *http.Request {
+	// the level is also set without c.Levels if it is not the default, such as the level of an escalated slow request
+	withLevel := c.Levels != nil || level != LevelInfo
+	if !withLevel && len(c.QueryRules) == 0 {
+		return r
+	}
+	ctx := r.Context()
+	if withLevel {
+		ctx = context.WithValue(ctx, LevelKey, level)
+	}
+	lr := r.WithContext(ctx)
+	lr.RequestURI = MaskRequestURI(r.RequestURI, c.QueryRules, c.QueryKey, mask)
+	return lr
+}
+func newHash(c LogConfig) hash.Hash {
+	if c.BodyHash {
+		return sha256.New()
+	}
+	return nil
+}
+func durationField(c LogConfig) string {
+	if len(c.Duration) > 0 {
+		return c.Duration
+	}
+	return "duration"
+}
 func getRemoteIp(r *http.Request) string {
 	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
@@ -0,0 +1,118 @@
+package echo
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// ProxyConfig resolves the client IP of requests received through trusted proxies.
+// TrustedProxies are IPs or CIDRs. Header is the only header read, "X-Forwarded-For" by default, or else "Forwarded"
+// (RFC 7239) or "X-Real-IP": it must be the header that the trusted proxies set, as a client can send the others.
+// The addresses of the header are walked right to left, and the first one that is not a trusted proxy is the client IP.
+// The header is ignored if the peer is not trusted.
+type ProxyConfig struct {
+	TrustedProxies []string `yaml:"trusted_proxies" mapstructure:"trusted_proxies" json:"trustedProxies,omitempty" gorm:"column:trustedproxies" bson:"trustedProxies,omitempty" dynamodbav:"trustedProxies,omitempty" firestore:"trustedProxies,omitempty"`
+	Header         string   `yaml:"header" mapstructure:"header" json:"header,omitempty" gorm:"column:header" bson:"header,omitempty" dynamodbav:"header,omitempty" firestore:"header,omitempty"`
+	nets           []*net.IPNet
+}
+
+// CompileProxyConfig returns a copy of c with its trusted proxies parsed and the default header set.
+func CompileProxyConfig(c *ProxyConfig) *ProxyConfig {
+	pc := *c
+	pc.nets = ParseIPNets(pc.TrustedProxies)
+	if len(pc.Header) == 0 {
+		pc.Header = HeaderXForwardedFor
+	}
+	return &pc
+}
+
+// ClientIP returns the IP of the client of r, resolved through the trusted proxies of c, or the IP of r.RemoteAddr if c is nil.
+func ClientIP(r *http.Request, c *ProxyConfig) string {
+	peer := getRemoteIp(r)
+	if c == nil {
+		return peer
+	}
+	if c.nets == nil && len(c.TrustedProxies) > 0 {
+		c = CompileProxyConfig(c)
+	}
+	if !ContainsIP(c.nets, peer) {
+		return peer
+	}
+	name := c.Header
+	if len(name) == 0 {
+		name = HeaderXForwardedFor
+	}
+	values := r.Header.Values(name)
+	if len(values) == 0 {
+		return peer
+	}
+	var addrs []string
+	switch http.CanonicalHeaderKey(name) {
+	case HeaderForwarded:
+		addrs = parseForwarded(values)
+	case http.CanonicalHeaderKey(HeaderXRealIP):
+		addrs = values[len(values)-1:]
+	default:
+		for _, v := range values {
+			addrs = append(addrs, strings.Split(v, ",")...)
+		}
+	}
+	return resolveClientIP(addrs, c.nets, peer)
+}
+
+// resolveClientIP walks addrs right to left and returns the first address that is not trusted.
+// If an address is invalid, such as a spoofed or obfuscated value, the last valid address is returned.
+func resolveClientIP(addrs []string, nets []*net.IPNet, peer string) string {
+	ip := peer
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr := parseForwardedIP(addrs[i])
+		if len(addr) == 0 {
+			return ip
+		}
+		ip = addr
+		if !ContainsIP(nets, addr) {
+			return addr
+		}
+	}
+	return ip
+}
+
+// parseForwarded returns the "for" addresses of Forwarded header values, in order.
+func parseForwarded(values []string) []string {
+	var addrs []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+					addrs = append(addrs, pair[4:])
+				}
+			}
+		}
+	}
+	return addrs
+}
+
+// parseForwardedIP returns the IP of s, without quotes, brackets or port, or "" if s is not an IP.
+func parseForwardedIP(s string) string {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if strings.HasPrefix(s, "[") {
+		if i := strings.IndexByte(s, ']'); i > 0 {
+			s = s[1:i]
+		}
+	} else if strings.Count(s, ":") == 1 {
+		s = s[:strings.IndexByte(s, ':')]
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
@@ -0,0 +1,316 @@
+package echo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeDenied  = "denied"
+	OutcomeFailure = "failure"
+	OutcomeError   = "error"
+
+	DefaultAuditLimit = 1 << 20
+)
+
+// AuditConfig configures the audit events. Only the requests matching one of Rules are audited.
+// The actor is the string context value of Actor, or else the ActorClaim of the claims map stored under the Claims context key.
+type AuditConfig struct {
+	Rules      []AuditRule `yaml:"rules" mapstructure:"rules" json:"rules,omitempty" gorm:"column:rules" bson:"rules,omitempty" dynamodbav:"rules,omitempty" firestore:"rules,omitempty"`
+	Actor      string      `yaml:"actor" mapstructure:"actor" json:"actor,omitempty" gorm:"column:actor" bson:"actor,omitempty" dynamodbav:"actor,omitempty" firestore:"actor,omitempty"`
+	Claims     string      `yaml:"claims" mapstructure:"claims" json:"claims,omitempty" gorm:"column:claims" bson:"claims,omitempty" dynamodbav:"claims,omitempty" firestore:"claims,omitempty"`
+	ActorClaim string      `yaml:"actor_claim" mapstructure:"actor_claim" json:"actorClaim,omitempty" gorm:"column:actorclaim" bson:"actorClaim,omitempty" dynamodbav:"actorClaim,omitempty" firestore:"actorClaim,omitempty"`
+	// Limit is the maximum number of request body bytes kept to read resource IDs, 1 MB by default.
+	Limit int `yaml:"limit" mapstructure:"limit" json:"limit,omitempty" gorm:"column:limit" bson:"limit,omitempty" dynamodbav:"limit,omitempty" firestore:"limit,omitempty"`
+	// Proxy resolves the client IP, as in LogConfig.
+	Proxy *ProxyConfig `yaml:"proxy" mapstructure:"proxy" json:"proxy,omitempty" gorm:"column:proxy" bson:"proxy,omitempty" dynamodbav:"proxy,omitempty" firestore:"proxy,omitempty"`
+	// IpConfig anonymizes the client IP of the events, as in LogConfig.
+	IpConfig `yaml:",inline" mapstructure:",squash"`
+}
+
+// AuditRule audits the requests matching its pattern. Action is "<method> <route>" by default.
+// Resources maps the name of a resource ID to its source: "param:id" for a path parameter, "query:id", "header:X-Id",
+// "context:key", or "body:data.id" for a field of a JSON request body, read by ValueOf.
+type AuditRule struct {
+	PathPattern `yaml:",inline" mapstructure:",squash"`
+	Action      string            `yaml:"action" mapstructure:"action" json:"action,omitempty" gorm:"column:action" bson:"action,omitempty" dynamodbav:"action,omitempty" firestore:"action,omitempty"`
+	Resource    string            `yaml:"resource" mapstructure:"resource" json:"resource,omitempty" gorm:"column:resource" bson:"resource,omitempty" dynamodbav:"resource,omitempty" firestore:"resource,omitempty"`
+	Resources   map[string]string `yaml:"resources" mapstructure:"resources" json:"resources,omitempty" gorm:"column:resources" bson:"resources,omitempty" dynamodbav:"resources,omitempty" firestore:"resources,omitempty"`
+}
+
+// AuditEvent is the stable schema of an audit record.
+type AuditEvent struct {
+	Time        time.Time              `json:"time"`
+	Actor       string                 `json:"actor,omitempty"`
+	Action      string                 `json:"action"`
+	Resource    string                 `json:"resource,omitempty"`
+	ResourceIDs map[string]interface{} `json:"resourceIds,omitempty"`
+	Outcome     string                 `json:"outcome"`
+	Status      int                    `json:"status"`
+	ClientIP    string                 `json:"clientIp,omitempty"`
+	RequestID   string                 `json:"requestId,omitempty"`
+}
+
+// Auditor sends the audit events to its own sink, separately from the access log: send receives the JSON of each event,
+// with the "time", "level" and "msg" keys renamed by KeyMap as in Send. Log, if set, also receives the events as fields.
+type Auditor struct {
+	Config   AuditConfig
+	send     func(context.Context, []byte, map[string]string) error
+	KeyMap   map[string]string
+	Log      func(ctx context.Context, msg string, fields map[string]interface{})
+	LogError func(ctx context.Context, msg string)
+	rules    []AuditRule
+	body     bool
+	// bodies reports, for each rule, whether it reads resource IDs from the body
+	bodies []bool
+}
+
+// NewAuditor creates an auditor. It panics if the rules or the IpConfig of c are invalid.
+func NewAuditor(c AuditConfig, send func(context.Context, []byte, map[string]string) error, options ...map[string]string) *Auditor {
+	var keyMap map[string]string
+	if len(options) >= 1 {
+		keyMap = options[0]
+	}
+	if c.Limit <= 0 {
+		c.Limit = DefaultAuditLimit
+	}
+	if c.Proxy != nil {
+		c.Proxy = CompileProxyConfig(c.Proxy)
+	}
+	ipConfig, err := CompileIpConfig(c.IpConfig)
+	if err != nil {
+		panic(err)
+	}
+	c.IpConfig = ipConfig
+	a := &Auditor{Config: c, send: send, KeyMap: keyMap, rules: make([]AuditRule, len(c.Rules)), bodies: make([]bool, len(c.Rules))}
+	for i, rule := range c.Rules {
+		if err := rule.Compile(); err != nil {
+			panic(err)
+		}
+		a.rules[i] = rule
+		for _, source := range rule.Resources {
+			if strings.HasPrefix(source, "body:") {
+				a.body = true
+				a.bodies[i] = true
+			}
+		}
+	}
+	return a
+}
+
+// captureBody keeps the request body while the handler reads it, if the rule matching r reads resource IDs from the body.
+// A rule matching the route template may match only once the request is routed, so the body is kept if it reads from it.
+func (a *Auditor) captureBody(r *http.Request) *SlowBody {
+	if !a.body || r.Body == nil || r.Body == http.NoBody || !a.readsBody(r) {
+		return nil
+	}
+	body := &SlowBody{ReadCloser: r.Body, Limit: a.Config.Limit}
+	r.Body = body
+	return body
+}
+
+func (a *Auditor) readsBody(r *http.Request) bool {
+	routed := len(GetRoute(r)) > 0
+	for i := range a.rules {
+		if a.rules[i].Route && !routed {
+			// the rule may match once the request is routed
+			if a.bodies[i] && a.rules[i].MatchMethod(r.Method) {
+				return true
+			}
+			continue
+		}
+		if a.rules[i].Matches(r) {
+			return a.bodies[i]
+		}
+	}
+	return false
+}
+
+func (a *Auditor) matchRule(r *http.Request) *AuditRule {
+	for i := range a.rules {
+		if a.rules[i].Matches(r) {
+			return &a.rules[i]
+		}
+	}
+	return nil
+}
+
+// BuildAuditEvent builds the event of a completed request. param returns the value of a path parameter.
+func (a *Auditor) BuildAuditEvent(r *http.Request, rule *AuditRule, param func(name string) string, body *SlowBody, status int, panicked bool) AuditEvent {
+	ctx := r.Context()
+	ip, _ := a.Config.Anonymize(ClientIP(r, a.Config.Proxy))
+	e := AuditEvent{
+		Time:      time.Now(),
+		Actor:     a.actor(ctx),
+		Action:    rule.Action,
+		Resource:  rule.Resource,
+		Outcome:   AuditOutcome(status, panicked),
+		Status:    status,
+		ClientIP:  ip,
+		RequestID: GetReqID(ctx),
+	}
+	if panicked {
+		e.Status = http.StatusInternalServerError
+	} else if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	if len(e.Action) == 0 {
+		route := GetRoute(r)
+		if len(route) == 0 {
+			route = r.URL.Path
+		}
+		e.Action = r.Method + " " + route
+	}
+	if len(rule.Resources) > 0 {
+		var v interface{}
+		if body != nil && body.Size <= int64(body.Limit) {
+			json.Unmarshal(body.Body.Bytes(), &v)
+		}
+		e.ResourceIDs = make(map[string]interface{}, len(rule.Resources))
+		for name, source := range rule.Resources {
+			if value := auditValue(r, source, param, v); value != nil && value != "" {
+				e.ResourceIDs[name] = value
+			}
+		}
+	}
+	return e
+}
+
+func (a *Auditor) actor(ctx context.Context) string {
+	if len(a.Config.Actor) > 0 {
+		if actor, ok := ctx.Value(a.Config.Actor).(string); ok && len(actor) > 0 {
+			return actor
+		}
+	}
+	if len(a.Config.Claims) > 0 && len(a.Config.ActorClaim) > 0 {
+		if claims, ok := ctx.Value(a.Config.Claims).(map[string]interface{}); ok {
+			if actor := ValueOf(claims, a.Config.ActorClaim); actor != nil {
+				return fmt.Sprint(actor)
+			}
+		}
+	}
+	return ""
+}
+
+func auditValue(r *http.Request, source string, param func(name string) string, body interface{}) interface{} {
+	i := strings.IndexByte(source, ':')
+	if i < 0 {
+		return nil
+	}
+	name := source[i+1:]
+	switch source[:i] {
+	case "param":
+		if param == nil {
+			return nil
+		}
+		return param(name)
+	case "query":
+		return r.URL.Query().Get(name)
+	case "header":
+		return r.Header.Get(name)
+	case "context":
+		return r.Context().Value(name)
+	case "body":
+		if body == nil {
+			return nil
+		}
+		return ValueOf(body, name)
+	default:
+		return nil
+	}
+}
+
+// AuditOutcome returns "error" for 5xx or a panic, "denied" for 401 and 403, "failure" for other 4xx, and "success" otherwise.
+func AuditOutcome(status int, panicked bool) string {
+	switch {
+	case panicked || status >= 500:
+		return OutcomeError
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return OutcomeDenied
+	case status >= 400:
+		return OutcomeFailure
+	default:
+		return OutcomeSuccess
+	}
+}
+
+// Write sends e to the sink of the auditor, and to Log if it is set. Errors of the sink are reported to LogError.
+func (a *Auditor) Write(ctx context.Context, e AuditEvent) {
+	fields := map[string]interface{}{
+		"actor":       e.Actor,
+		"action":      e.Action,
+		"resource":    e.Resource,
+		"resourceIds": e.ResourceIDs,
+		"outcome":     e.Outcome,
+		"status":      e.Status,
+		"clientIp":    e.ClientIP,
+		"requestId":   e.RequestID,
+	}
+	for k, v := range fields {
+		if v == "" || v == nil {
+			delete(fields, k)
+		}
+	}
+	if len(e.ResourceIDs) == 0 {
+		delete(fields, "resourceIds")
+	}
+	if a.Log != nil {
+		a.Log(ctx, e.Action, fields)
+	}
+	if a.send == nil {
+		return
+	}
+	m := AddKeyFieldsWithLevel(e.Action, LevelInfo, copyAuditFields(fields), a.KeyMap)
+	t := "time"
+	if v, ok := a.KeyMap[t]; ok && len(v) > 0 {
+		t = v
+	}
+	m[t] = e.Time
+	b, err := json.Marshal(m)
+	if err == nil {
+		err = a.send(ctx, b, nil)
+	}
+	if err != nil && a.LogError != nil {
+		a.LogError(ctx, "cannot send audit event "+e.Action+": "+err.Error())
+	}
+}
+
+func copyAuditFields(fields map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(fields)+3)
+	for k, v := range fields {
+		m[k] = v
+	}
+	return m
+}
+
+// Audit sends an audit event for each request matching a rule, once the handler returns.
+func (a *Auditor) Audit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if route := c.Path(); len(route) > 0 {
+			c.SetRequest(WithRoute(c.Request(), route))
+		}
+		body := a.captureBody(c.Request())
+		completed := false
+		defer func() {
+			// c.Request() may carry the actor set by the next handlers
+			r := c.Request()
+			if rule := a.matchRule(r); rule != nil {
+				a.Write(r.Context(), a.BuildAuditEvent(r, rule, c.Param, body, c.Response().Status, !completed))
+			}
+		}()
+		err := next(c)
+		completed = true
+		if err != nil {
+			// let the error handler write the response now, so that its status is audited
+			c.Error(err)
+		}
+		// the error is handled, echo must not handle it again
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package echo
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// CaptureRule logs the full bodies of the requests matching its pattern and client IP, until Until.
+// An empty Path matches all paths, an empty Ip all clients. Ip is an IP or a CIDR.
+type CaptureRule struct {
+	PathPattern `yaml:",inline" mapstructure:",squash"`
+	Ip          string    `yaml:"ip" mapstructure:"ip" json:"ip,omitempty" gorm:"column:ip" bson:"ip,omitempty" dynamodbav:"ip,omitempty" firestore:"ip,omitempty"`
+	Until       time.Time `yaml:"until" mapstructure:"until" json:"until,omitempty" gorm:"column:until" bson:"until,omitempty" dynamodbav:"until,omitempty" firestore:"until,omitempty"`
+	nets        []*net.IPNet
+}
+
+// CompileCaptureRules returns compiled copies of rules.
+func CompileCaptureRules(rules []CaptureRule) ([]CaptureRule, error) {
+	compiled := make([]CaptureRule, len(rules))
+	for i, rule := range rules {
+		if err := rule.Compile(); err != nil {
+			return nil, err
+		}
+		if len(rule.Ip) > 0 {
+			rule.nets = ParseIPNets([]string{rule.Ip})
+		}
+		compiled[i] = rule
+	}
+	return compiled, nil
+}
+
+// MatchCapture returns the first of the compiled rules that is active and matches r, or nil.
+func MatchCapture(r *http.Request, rules []CaptureRule, proxy *ProxyConfig) *CaptureRule {
+	now := time.Now()
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Until.IsZero() && !now.Before(rule.Until) {
+			continue
+		}
+		if len(rule.Path) > 0 && !rule.Matches(r) {
+			continue
+		}
+		if len(rule.Ip) > 0 && !ContainsIP(rule.nets, ClientIP(r, proxy)) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+// CaptureBodies returns c and fc changed to log the whole bodies of r, if r matches a capture rule of fc.
+// Sampling and deferred capture do not apply to the captured requests.
+func CaptureBodies(r *http.Request, c LogConfig, fc *FieldConfig) (LogConfig, *FieldConfig) {
+	if len(fc.Captures) == 0 || MatchCapture(r, fc.Captures, fc.Proxy) == nil {
+		return c, fc
+	}
+	return captureAll(c, fc)
+}
+
+func captureAll(c LogConfig, fc *FieldConfig) (LogConfig, *FieldConfig) {
+	if len(c.Request) == 0 {
+		c.Request = "request"
+	}
+	if len(c.Response) == 0 {
+		c.Response = "response"
+	}
+	c.RequestLimit, c.ResponseLimit = 0, 0
+	captured := *fc
+	captured.Log = true
+	captured.Sampling = nil
+	captured.Deferred = nil
+	return c, &captured
+}
@@ -4,41 +4,51 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"strings"
 )
 
+// BuildContextWithMask uses the package-level config set by InitializeFieldConfig.
+// Use EchoLogger.BuildContextWithMask to build the context from the config of a logger instance.
 func BuildContextWithMask(next http.Handler, mask func(fieldName, s string) string) http.Handler {
+	return buildContextWithMask(next, func() *FieldConfig {
+		return &fieldConfig
+	}, mask)
+}
+func buildContextWithMask(next http.Handler, load func() *FieldConfig, mask func(fieldName, s string) string) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
+		fc := load()
 		var ctx context.Context
 		ctx = r.Context()
-		if len(fieldConfig.Ip) > 0 {
-			ip := getRemoteIp(r)
-			ctx = context.WithValue(ctx, fieldConfig.Ip, ip)
+		if len(fc.Ip) > 0 {
+			if ip, ok := fc.Anonymize(ClientIP(r, fc.Proxy)); ok {
+				ctx = context.WithValue(ctx, fc.Ip, ip)
+			}
 		}
-		if fieldConfig.Constants != nil && len(fieldConfig.Constants) > 0 {
-			for k, e := range fieldConfig.Constants {
+		if fc.Constants != nil && len(fc.Constants) > 0 {
+			for k, e := range fc.Constants {
 				if len(e) > 0 {
 					ctx = context.WithValue(ctx, k, e)
 				}
 			}
 		}
-		if fieldConfig.Headers != nil && len(fieldConfig.Headers) > 0 {
-			for k, e := range fieldConfig.Headers {
+		if fc.Headers != nil && len(fc.Headers) > 0 {
+			for k, e := range fc.Headers {
 				if len(e) > 0 {
 					header := r.Header.Get(e)
 					ctx = context.WithValue(ctx, k, header)
 				}
 			}
 		}
-		if fieldConfig.Map != nil && len(fieldConfig.Map) > 0 && r.Body != nil && (r.Method != "GET" || r.Method != "DELETE") {
+		if fc.Map != nil && len(fc.Map) > 0 && r.Body != nil && (r.Method != "GET" && r.Method != "DELETE") {
 			buf := new(bytes.Buffer)
 			buf.ReadFrom(r.Body)
 			r.Body = io.NopCloser(buf)
 			var v interface{}
 			er2 := json.NewDecoder(strings.NewReader(buf.String())).Decode(&v)
 			if er2 != nil {
-				if len(fieldConfig.Ip) == 0 && fieldConfig.Constants == nil {
+				if len(fc.Ip) == 0 && fc.Constants == nil {
 					next.ServeHTTP(w, r)
 				} else {
 					next.ServeHTTP(w, r.WithContext(ctx))
@@ -46,21 +56,21 @@ func BuildContextWithMask(next http.Handler, mask func(fieldName, s string) stri
 			} else {
 				m, ok := v.(map[string]interface{})
 				if !ok {
-					if len(fieldConfig.Ip) == 0 && fieldConfig.Constants == nil {
+					if len(fc.Ip) == 0 && fc.Constants == nil {
 						next.ServeHTTP(w, r)
 					} else {
 						next.ServeHTTP(w, r.WithContext(ctx))
 					}
 				} else {
-					for k, e := range fieldConfig.Map {
+					for k, e := range fc.Map {
 						if strings.Index(e, ".") >= 0 {
 							v3 := ValueOf(v, e)
 							if v3 != nil {
 								s3, ok3 := v3.(string)
 								if ok3 {
 									if len(s3) > 0 {
-										if mask != nil && fieldConfig.Masks != nil && len(fieldConfig.Masks) > 0 {
-											if Include(fieldConfig.Masks, k) {
+										if mask != nil && fc.Masks != nil && len(fc.Masks) > 0 {
+											if Include(fc.Masks, k) {
 												ctx = context.WithValue(ctx, k, mask(k, s3))
 											} else {
 												ctx = context.WithValue(ctx, k, s3)
@@ -79,8 +89,8 @@ func BuildContextWithMask(next http.Handler, mask func(fieldName, s string) stri
 								s3, ok3 := x.(string)
 								if ok3 {
 									if len(s3) > 0 {
-										if mask != nil && fieldConfig.Masks != nil && len(fieldConfig.Masks) > 0 {
-											if Include(fieldConfig.Masks, k) {
+										if mask != nil && fc.Masks != nil && len(fc.Masks) > 0 {
+											if Include(fc.Masks, k) {
 												ctx = context.WithValue(ctx, k, mask(k, s3))
 											} else {
 												ctx = context.WithValue(ctx, k, s3)
@@ -99,7 +109,7 @@ func BuildContextWithMask(next http.Handler, mask func(fieldName, s string) stri
 				}
 			}
 		} else {
-			if len(fieldConfig.Ip) == 0 && fieldConfig.Constants == nil {
+			if len(fc.Ip) == 0 && fc.Constants == nil && fc.Headers == nil {
 				next.ServeHTTP(w, r)
 			} else {
 				next.ServeHTTP(w, r.WithContext(ctx))
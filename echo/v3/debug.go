@@ -0,0 +1,134 @@
+package echo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ctxKeyDebug int
+
+// DebugKey is the key that holds the issuer of the debug token of a request in a request context.
+const DebugKey ctxKeyDebug = 0
+
+// DebugConfig lets a request carrying a valid debug token in Header ("X-Debug-Token" by default) be logged in full:
+// bodies and headers are captured, and sampling, skip rules and Separate do not apply.
+// Tokens are signed with Key by NewDebugToken. The issuer of the token is logged into Field, "debug_by" by default.
+// Headers are used if LogConfig.LogHeaders is not set, "request_headers" and "response_headers" by default.
+type DebugConfig struct {
+	Header  string        `yaml:"header" mapstructure:"header" json:"header,omitempty" gorm:"column:header" bson:"header,omitempty" dynamodbav:"header,omitempty" firestore:"header,omitempty"`
+	Key     string        `yaml:"key" mapstructure:"key" json:"key,omitempty" gorm:"column:key" bson:"key,omitempty" dynamodbav:"key,omitempty" firestore:"key,omitempty"`
+	Field   string        `yaml:"field" mapstructure:"field" json:"field,omitempty" gorm:"column:field" bson:"field,omitempty" dynamodbav:"field,omitempty" firestore:"field,omitempty"`
+	Headers *HeaderConfig `yaml:"headers" mapstructure:"headers" json:"headers,omitempty" gorm:"column:headers" bson:"headers,omitempty" dynamodbav:"headers,omitempty" firestore:"headers,omitempty"`
+}
+
+type debugClaims struct {
+	Issuer    string `json:"iss"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// CompileDebugConfig returns a copy of c with its defaults set, or an error if it has no key.
+func CompileDebugConfig(c *DebugConfig) (*DebugConfig, error) {
+	if len(c.Key) == 0 {
+		return nil, errors.New("debug config requires a key")
+	}
+	d := *c
+	if len(d.Header) == 0 {
+		d.Header = "X-Debug-Token"
+	}
+	if len(d.Field) == 0 {
+		d.Field = "debug_by"
+	}
+	if d.Headers == nil {
+		d.Headers = &HeaderConfig{Request: "request_headers", Response: "response_headers"}
+	}
+	return &d, nil
+}
+
+// NewDebugToken returns a token issued by issuer, valid for ttl, signed with key.
+func NewDebugToken(key string, issuer string, ttl time.Duration) string {
+	payload, _ := json.Marshal(debugClaims{Issuer: issuer, ExpiresAt: time.Now().Add(ttl).Unix()})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signDebugToken(key, encoded))
+}
+
+// VerifyDebugToken returns the issuer of token, or an error if it is not signed with key or has expired.
+func VerifyDebugToken(token string, key string) (string, error) {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return "", errors.New("malformed debug token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(signature, signDebugToken(key, token[:i])) {
+		return "", errors.New("invalid debug token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(token[:i])
+	if err != nil {
+		return "", errors.New("malformed debug token")
+	}
+	var claims debugClaims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return "", errors.New("malformed debug token")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return "", fmt.Errorf("debug token of %q has expired", claims.Issuer)
+	}
+	return claims.Issuer, nil
+}
+
+func signDebugToken(key string, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// DebugRequest returns r with the issuer of its debug token in its context, and c and fc changed to log r in full.
+// It returns r, c and fc unchanged if r has no debug token, with an error if the token is invalid or has expired.
+func DebugRequest(r *http.Request, c LogConfig, fc *FieldConfig) (*http.Request, LogConfig, *FieldConfig, error) {
+	if fc.Debug == nil {
+		return r, c, fc, nil
+	}
+	token := r.Header.Get(fc.Debug.Header)
+	if len(token) == 0 {
+		return r, c, fc, nil
+	}
+	issuer, err := VerifyDebugToken(token, fc.Debug.Key)
+	if err != nil {
+		return r, c, fc, err
+	}
+	// the token must not be logged
+	headers := fc.Debug.Headers
+	if c.LogHeaders != nil {
+		headers = c.LogHeaders
+	}
+	h := *headers
+	h.Redact = append(append([]string(nil), h.Redact...), fc.Debug.Header)
+	c.LogHeaders = &h
+	c.Separate = false
+	c, fc = captureAll(c, fc)
+	debug := *fc
+	debug.Skips = nil
+	debug.SkipRules = nil
+	return r.WithContext(context.WithValue(r.Context(), DebugKey, issuer)), c, &debug, nil
+}
+
+// BuildDebugField adds the issuer of the debug token of r into fields.
+func BuildDebugField(c LogConfig, r *http.Request, fields map[string]interface{}) {
+	if c.Debug == nil {
+		return
+	}
+	if issuer, ok := r.Context().Value(DebugKey).(string); ok {
+		field := c.Debug.Field
+		if len(field) == 0 {
+			field = "debug_by"
+		}
+		fields[field] = issuer
+	}
+}
@@ -0,0 +1,67 @@
+package echo
+
+import "fmt"
+
+const DefaultDeferredLimit = 65536
+
+// DeferredConfig buffers the request and response bodies up to Limit bytes, 64 KiB by default, and logs them only if the response status
+// matches Status, "4xx" and "5xx" by default, or if Errors is set and the handler panicked or failed.
+// Otherwise only the metadata is logged. It also applies to the bodies of escalated slow requests.
+type DeferredConfig struct {
+	Status []string `yaml:"status" mapstructure:"status" json:"status,omitempty" gorm:"column:status" bson:"status,omitempty" dynamodbav:"status,omitempty" firestore:"status,omitempty"`
+	Errors bool     `yaml:"errors" mapstructure:"errors" json:"errors,omitempty" gorm:"column:errors" bson:"errors,omitempty" dynamodbav:"errors,omitempty" firestore:"errors,omitempty"`
+	Limit  int      `yaml:"limit" mapstructure:"limit" json:"limit,omitempty" gorm:"column:limit" bson:"limit,omitempty" dynamodbav:"limit,omitempty" firestore:"limit,omitempty"`
+}
+
+// CompileDeferredConfig validates c and returns a copy with defaults.
+func CompileDeferredConfig(c *DeferredConfig) (*DeferredConfig, error) {
+	if c == nil {
+		return nil, nil
+	}
+	d := *c
+	// the bodies are buffered for every request, so the buffers are always bounded
+	if d.Limit <= 0 {
+		d.Limit = DefaultDeferredLimit
+	}
+	if len(d.Status) == 0 {
+		d.Status = []string{"4xx", "5xx"}
+	}
+	for _, status := range d.Status {
+		if !isStatusPattern(status) {
+			return nil, fmt.Errorf("invalid deferred capture status %q", status)
+		}
+	}
+	return &d, nil
+}
+
+// DeferredLimit returns the capture limit of a body: the smaller of limit and c.Limit, ignoring the ones <= 0.
+func DeferredLimit(limit int, c *DeferredConfig) int {
+	if c == nil || c.Limit <= 0 {
+		return limit
+	}
+	if limit <= 0 || c.Limit < limit {
+		return c.Limit
+	}
+	return limit
+}
+
+// KeepBodies reports whether the buffered bodies of a response are logged.
+func KeepBodies(c *DeferredConfig, status int, failed bool) bool {
+	if c.Errors && failed {
+		return true
+	}
+	if status == 0 {
+		return false
+	}
+	return MatchStatus(status, c.Status)
+}
+
+// DiscardBodies removes the request body from fields, and returns c without the Response key,
+// so that the Formatter does not log the response body.
+func DiscardBodies(c LogConfig, fields map[string]interface{}) LogConfig {
+	if len(c.Request) > 0 {
+		delete(fields, c.Request)
+	}
+	c.Response = ""
+	return c
+}
@@ -0,0 +1,178 @@
+package echo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	OverflowBlock      = "block"
+	OverflowDropNewest = "drop_newest"
+	OverflowDropOldest = "drop_oldest"
+
+	DefaultQueueSize = 1024
+	DefaultWorkers   = 4
+)
+
+// AsyncConfig bounds the goroutines used for logging: records are queued and written by a fixed number of workers.
+// Overflow decides what happens when the queue is full: "block" (default), "drop_newest" or "drop_oldest".
+type AsyncConfig struct {
+	QueueSize int    `yaml:"queue_size" mapstructure:"queue_size" json:"queueSize,omitempty" gorm:"column:queuesize" bson:"queueSize,omitempty" dynamodbav:"queueSize,omitempty" firestore:"queueSize,omitempty"`
+	Workers   int    `yaml:"workers" mapstructure:"workers" json:"workers,omitempty" gorm:"column:workers" bson:"workers,omitempty" dynamodbav:"workers,omitempty" firestore:"workers,omitempty"`
+	Overflow  string `yaml:"overflow" mapstructure:"overflow" json:"overflow,omitempty" gorm:"column:overflow" bson:"overflow,omitempty" dynamodbav:"overflow,omitempty" firestore:"overflow,omitempty"`
+}
+
+type LogQueue struct {
+	dropped  int64
+	pending  int64
+	overflow string
+	jobs     chan func()
+	mu       sync.RWMutex
+	closed   bool
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+// ValidateAsyncConfig returns an error if the overflow policy of c is unknown.
+func ValidateAsyncConfig(c AsyncConfig) error {
+	switch strings.ToLower(c.Overflow) {
+	case "", OverflowBlock, OverflowDropNewest, OverflowDropOldest:
+		return nil
+	default:
+		return fmt.Errorf("invalid overflow policy %q", c.Overflow)
+	}
+}
+
+// NewLogQueue starts the workers of a queue. c must be validated by ValidateAsyncConfig: an unknown overflow policy blocks.
+func NewLogQueue(c AsyncConfig) *LogQueue {
+	size := c.QueueSize
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	workers := c.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	q := &LogQueue{overflow: strings.ToLower(c.Overflow), jobs: make(chan func(), size), done: make(chan struct{})}
+	q.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *LogQueue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		job()
+		atomic.AddInt64(&q.pending, -1)
+	}
+}
+
+// Enqueue adds a job to the queue, applying the overflow policy if it is full.
+// It returns false if the job was dropped, because of the policy or because the queue is shut down.
+func (q *LogQueue) Enqueue(job func()) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		atomic.AddInt64(&q.dropped, 1)
+		return false
+	}
+	atomic.AddInt64(&q.pending, 1)
+	switch q.overflow {
+	case OverflowDropNewest:
+		select {
+		case q.jobs <- job:
+			return true
+		default:
+			atomic.AddInt64(&q.pending, -1)
+			atomic.AddInt64(&q.dropped, 1)
+			return false
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case q.jobs <- job:
+				return true
+			default:
+			}
+			select {
+			case <-q.jobs:
+				atomic.AddInt64(&q.pending, -1)
+				atomic.AddInt64(&q.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case q.jobs <- job:
+			return true
+		case <-q.done:
+			// Shutdown releases the blocked senders, so that it can close the queue
+			atomic.AddInt64(&q.pending, -1)
+			atomic.AddInt64(&q.dropped, 1)
+			return false
+		}
+	}
+}
+
+// Dropped returns the number of records dropped since the queue was created.
+func (q *LogQueue) Dropped() int64 {
+	return atomic.LoadInt64(&q.dropped)
+}
+
+// Pending returns the number of records queued or being written.
+func (q *LogQueue) Pending() int64 {
+	return atomic.LoadInt64(&q.pending)
+}
+
+// Flush waits until all queued records are written, or ctx is done.
+func (q *LogQueue) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for q.Pending() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Shutdown stops accepting records and waits until the queued records are written, or ctx is done.
+// Records enqueued after Shutdown, or blocked on a full queue, are dropped.
+func (q *LogQueue) Shutdown(ctx context.Context) error {
+	q.once.Do(func() {
+		close(q.done)
+		q.mu.Lock()
+		q.closed = true
+		close(q.jobs)
+		q.mu.Unlock()
+	})
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dispatch runs job on q, or on a new goroutine if q is nil.
+func Dispatch(q *LogQueue, job func()) {
+	if q == nil {
+		go job()
+		return
+	}
+	q.Enqueue(job)
+}
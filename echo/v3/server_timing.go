@@ -0,0 +1,266 @@
+package echo
+
+import (
+	"bufio"
+	"context"
+	"github.com/labstack/echo"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ServerTimingHeader       = "Server-Timing"
+	DefaultServerTimingTotal = "total"
+)
+
+type ctxKeyServerTiming int
+
+// ServerTimingKey is the key that holds the ServerTimings of a request in a request context.
+const ServerTimingKey ctxKeyServerTiming = 0
+
+// ServerTimingConfig configures the Server-Timing header. Total is the name of the handler time, "total" by default.
+// If IPs or Header are set, the header is only sent to the clients whose IP matches one of IPs, an IP or a CIDR,
+// or whose request has Header. The metrics are logged in any case.
+type ServerTimingConfig struct {
+	Total  string   `yaml:"total" mapstructure:"total" json:"total,omitempty" gorm:"column:total" bson:"total,omitempty" dynamodbav:"total,omitempty" firestore:"total,omitempty"`
+	IPs    []string `yaml:"ips" mapstructure:"ips" json:"ips,omitempty" gorm:"column:ips" bson:"ips,omitempty" dynamodbav:"ips,omitempty" firestore:"ips,omitempty"`
+	Header string   `yaml:"header" mapstructure:"header" json:"header,omitempty" gorm:"column:header" bson:"header,omitempty" dynamodbav:"header,omitempty" firestore:"header,omitempty"`
+}
+
+type ServerTimingMetric struct {
+	Name        string
+	Duration    time.Duration
+	Description string
+}
+
+// ServerTimings collects the metrics of a request. It is safe for concurrent use.
+type ServerTimings struct {
+	mu      sync.Mutex
+	metrics []ServerTimingMetric
+}
+
+func (s *ServerTimings) Add(name string, d time.Duration, description string) {
+	s.mu.Lock()
+	s.metrics = append(s.metrics, ServerTimingMetric{Name: name, Duration: d, Description: description})
+	s.mu.Unlock()
+}
+
+func (s *ServerTimings) Metrics() []ServerTimingMetric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ServerTimingMetric(nil), s.metrics...)
+}
+
+// GetServerTimings returns the ServerTimings of the given context, or nil if ServerTiming is not installed.
+func GetServerTimings(ctx context.Context) *ServerTimings {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(ServerTimingKey).(*ServerTimings)
+	return s
+}
+
+// AddServerTiming registers a metric for the Server-Timing header of the request of ctx.
+// Metrics added after the response header is written are only logged.
+func AddServerTiming(ctx context.Context, name string, d time.Duration, description ...string) {
+	s := GetServerTimings(ctx)
+	if s == nil {
+		return
+	}
+	var desc string
+	if len(description) > 0 {
+		desc = description[0]
+	}
+	s.Add(name, d, desc)
+}
+
+// StartServerTiming starts timing a metric, and returns the function that registers it.
+func StartServerTiming(ctx context.Context, name string, description ...string) func() {
+	start := time.Now()
+	return func() {
+		AddServerTiming(ctx, name, time.Since(start), description...)
+	}
+}
+
+// FormatServerTiming formats metrics as a Server-Timing header value, with durations in milliseconds.
+func FormatServerTiming(metrics []ServerTimingMetric) string {
+	entries := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		entry := serverTimingToken(m.Name) + ";dur=" + strconv.FormatFloat(float64(m.Duration)/float64(time.Millisecond), 'f', 3, 64)
+		if len(m.Description) > 0 {
+			entry += ";desc=" + strconv.Quote(m.Description)
+		}
+		entries = append(entries, entry)
+	}
+	return strings.Join(entries, ", ")
+}
+
+// serverTimingToken replaces the characters that are not allowed in a metric name.
+func serverTimingToken(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7f && !strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// ParseIPNets parses IPs and CIDRs. An IP is a network of one address. Invalid entries are skipped.
+func ParseIPNets(ips []string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range ips {
+		s = strings.TrimSpace(s)
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			nets = append(nets, n)
+		} else if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		}
+	}
+	return nets
+}
+
+// ContainsIP reports whether ip is in one of nets.
+func ContainsIP(nets []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowServerTiming reports whether the Server-Timing header is sent to the client of r.
+// The client IP is the address of the connection, not a forwarded header, which clients can forge.
+func AllowServerTiming(r *http.Request, c ServerTimingConfig, nets []*net.IPNet) bool {
+	if len(c.IPs) == 0 && len(c.Header) == 0 {
+		return true
+	}
+	if len(c.Header) > 0 && len(r.Header.Get(c.Header)) > 0 {
+		return true
+	}
+	return ContainsIP(nets, getRemoteIp(r))
+}
+
+// BuildServerTimingFields adds the metrics of the request of r into fields[c.ServerTiming], in c.DurationUnit.
+func BuildServerTimingFields(c LogConfig, r *http.Request, fields map[string]interface{}) {
+	if len(c.ServerTiming) == 0 {
+		return
+	}
+	s := GetServerTimings(r.Context())
+	if s == nil {
+		return
+	}
+	metrics := s.Metrics()
+	if len(metrics) == 0 {
+		return
+	}
+	m := make(map[string]interface{}, len(metrics))
+	for _, metric := range metrics {
+		m[serverTimingToken(metric.Name)] = FormatDuration(metric.Duration, c.DurationUnit)
+	}
+	fields[c.ServerTiming] = m
+}
+
+// serverTiming adds the total metric and sets the Server-Timing header once, before the response header is written.
+type serverTiming struct {
+	timings *ServerTimings
+	start   time.Time
+	total   string
+	send    bool
+	done    bool
+}
+
+func newServerTiming(r *http.Request, c ServerTimingConfig, nets []*net.IPNet) *serverTiming {
+	total := c.Total
+	if len(total) == 0 {
+		total = DefaultServerTimingTotal
+	}
+	return &serverTiming{timings: &ServerTimings{}, start: time.Now(), total: total, send: AllowServerTiming(r, c, nets)}
+}
+
+func (t *serverTiming) write(header http.Header) {
+	if t.done {
+		return
+	}
+	t.done = true
+	t.timings.Add(t.total, time.Since(t.start), "")
+	if t.send {
+		header.Set(ServerTimingHeader, FormatServerTiming(t.timings.Metrics()))
+	}
+}
+
+type serverTimingWriter struct {
+	http.ResponseWriter
+	*serverTiming
+}
+
+func (w *serverTimingWriter) WriteHeader(statusCode int) {
+	w.write(w.Header())
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *serverTimingWriter) Write(b []byte) (int, error) {
+	w.write(w.Header())
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *serverTimingWriter) Flush() {
+	w.write(w.Header())
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack delegates to the wrapped writer, so that the handlers can still upgrade connections, such as websockets.
+func (w *serverTimingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *serverTimingWriter) Push(target string, opts *http.PushOptions) error {
+	if ps, ok := w.ResponseWriter.(http.Pusher); ok {
+		return ps.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (w *serverTimingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// ServerTiming writes the Server-Timing header, with the handler time until the response header is written
+// and the metrics added by AddServerTiming. Install it before the logger, which logs the metrics into LogConfig.ServerTiming.
+func ServerTiming(conf ServerTimingConfig) echo.MiddlewareFunc {
+	nets := ParseIPNets(conf.IPs)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			t := newServerTiming(r, conf, nets)
+			w := c.Response().Writer
+			c.Response().Writer = &serverTimingWriter{ResponseWriter: w, serverTiming: t}
+			c.SetRequest(r.WithContext(context.WithValue(r.Context(), ServerTimingKey, t.timings)))
+			err := next(c)
+			if err != nil {
+				// let the error handler write the response now, so that it has the header
+				c.Error(err)
+			}
+			t.write(w.Header())
+			// the error is handled, echo must not handle it again
+			return nil
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package echo
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultSlowField    = "slow"
+	DefaultSlowLimit    = 4096
+	DefaultSlowRequest  = "request"
+	DefaultSlowResponse = "response"
+)
+
+// SlowConfig escalates the requests slower than their threshold in milliseconds: the first matching rule, or Threshold.
+// An escalated request is always logged, at least at Level ("warn" by default), with its request and response bodies
+// up to Limit bytes and its headers, even if they are not logged otherwise. Field is set to true on its record.
+type SlowConfig struct {
+	Threshold int64      `yaml:"threshold" mapstructure:"threshold" json:"threshold,omitempty" gorm:"column:threshold" bson:"threshold,omitempty" dynamodbav:"threshold,omitempty" firestore:"threshold,omitempty"`
+	Rules     []SlowRule `yaml:"rules" mapstructure:"rules" json:"rules,omitempty" gorm:"column:rules" bson:"rules,omitempty" dynamodbav:"rules,omitempty" firestore:"rules,omitempty"`
+	Level     string     `yaml:"level" mapstructure:"level" json:"level,omitempty" gorm:"column:level" bson:"level,omitempty" dynamodbav:"level,omitempty" firestore:"level,omitempty"`
+	Limit     int        `yaml:"limit" mapstructure:"limit" json:"limit,omitempty" gorm:"column:limit" bson:"limit,omitempty" dynamodbav:"limit,omitempty" firestore:"limit,omitempty"`
+	// Headers are used if LogConfig.LogHeaders is not set, "request_headers" and "response_headers" by default.
+	Headers *HeaderConfig `yaml:"headers" mapstructure:"headers" json:"headers,omitempty" gorm:"column:headers" bson:"headers,omitempty" dynamodbav:"headers,omitempty" firestore:"headers,omitempty"`
+	Field   string        `yaml:"field" mapstructure:"field" json:"field,omitempty" gorm:"column:field" bson:"field,omitempty" dynamodbav:"field,omitempty" firestore:"field,omitempty"`
+}
+
+type SlowRule struct {
+	PathPattern `yaml:",inline" mapstructure:",squash"`
+	Threshold   int64 `yaml:"threshold" mapstructure:"threshold" json:"threshold,omitempty" gorm:"column:threshold" bson:"threshold,omitempty" dynamodbav:"threshold,omitempty" firestore:"threshold,omitempty"`
+}
+
+// CompileSlowConfig validates c and returns a copy with compiled rules and defaults.
+func CompileSlowConfig(c *SlowConfig) (*SlowConfig, error) {
+	if c == nil {
+		return nil, nil
+	}
+	s := *c
+	if len(s.Field) == 0 {
+		s.Field = DefaultSlowField
+	}
+	if len(s.Level) == 0 {
+		s.Level = LevelWarn
+	}
+	if _, ok := levelRanks[s.Level]; !ok {
+		return nil, fmt.Errorf("invalid slow level %q", s.Level)
+	}
+	if s.Limit <= 0 {
+		s.Limit = DefaultSlowLimit
+	}
+	if s.Headers == nil {
+		s.Headers = &HeaderConfig{Request: "request_headers", Response: "response_headers"}
+	}
+	if len(c.Rules) > 0 {
+		s.Rules = make([]SlowRule, len(c.Rules))
+		for i, rule := range c.Rules {
+			if err := rule.Compile(); err != nil {
+				return nil, err
+			}
+			s.Rules[i] = rule
+		}
+	}
+	return &s, nil
+}
+
+// SlowThreshold returns the threshold of r, 0 if it is never escalated. c must be compiled.
+func SlowThreshold(r *http.Request, c *SlowConfig) time.Duration {
+	for i := range c.Rules {
+		if c.Rules[i].Matches(r) {
+			return time.Duration(c.Rules[i].Threshold) * time.Millisecond
+		}
+	}
+	return time.Duration(c.Threshold) * time.Millisecond
+}
+
+// IsSlow reports whether a request that took elapsed is escalated.
+func IsSlow(r *http.Request, c *SlowConfig, elapsed time.Duration) bool {
+	if c == nil {
+		return false
+	}
+	threshold := SlowThreshold(r, c)
+	return threshold > 0 && elapsed > threshold
+}
+
+// SlowBody keeps the first Limit bytes of a request body that is not logged, while the handler reads it,
+// so that it can be logged if the request is escalated.
+type SlowBody struct {
+	io.ReadCloser
+	Body  bytes.Buffer
+	Limit int
+	Size  int64
+}
+
+func (b *SlowBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if remaining := b.Limit - b.Body.Len(); remaining > 0 {
+		if n < remaining {
+			remaining = n
+		}
+		b.Body.Write(p[:remaining])
+	}
+	b.Size += int64(n)
+	return n, err
+}
+
+// Value returns the kept body: a string, or a TruncatedBody if the handler read more than Limit bytes.
+// A truncated body cannot be masked, so the MaskLogger logs only its size.
+func (b *SlowBody) Value() interface{} {
+	if b.Size > int64(b.Body.Len()) {
+		return TruncatedBody{Body: b.Body.String(), Truncated: true, Size: b.Size}
+	}
+	return b.Body.String()
+}
+
+// CaptureSlowRequest wraps the body of r into a SlowBody if c is set and its content type is captured.
+func CaptureSlowRequest(r *http.Request, c *SlowConfig, types []string) *SlowBody {
+	if c == nil || r.Body == nil || r.Body == http.NoBody || !CaptureRequestType(r.Header.Get("Content-Type"), types) {
+		return nil
+	}
+	body := &SlowBody{ReadCloser: r.Body, Limit: c.Limit}
+	r.Body = body
+	return body
+}
+
+// EscalateSlow adds the request body and headers of an escalated request to fields, and returns the config
+// and the response body to pass to the Formatter, so that it logs the response body and headers too.
+func EscalateSlow(c LogConfig, sc *SlowConfig, r *http.Request, body *SlowBody, response string, fields map[string]interface{}) (LogConfig, string) {
+	if len(c.Request) == 0 {
+		c.Request = DefaultSlowRequest
+	}
+	if _, ok := fields[c.Request]; !ok && body != nil && body.Size > 0 {
+		fields[c.Request] = body.Value()
+	}
+	if len(c.Response) == 0 {
+		c.Response = DefaultSlowResponse
+		limit := sc.Limit
+		if c.ResponseLimit > 0 && c.ResponseLimit < limit {
+			limit = c.ResponseLimit
+		}
+		if len(response) > limit {
+			response = response[:limit]
+		}
+		c.ResponseLimit = limit
+	}
+	if c.LogHeaders == nil {
+		c.LogHeaders = sc.Headers
+		BuildHeaders(r.Header, c.LogHeaders.Request, c.LogHeaders, fields)
+	}
+	fields[sc.Field] = true
+	return c, response
+}
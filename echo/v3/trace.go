@@ -0,0 +1,177 @@
+package echo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/labstack/echo"
+	"net/http"
+	"strings"
+)
+
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+type ctxKeyTrace int
+
+// TraceKey is the key that holds the Trace of a request in a request context, next to RequestIDKey.
+const TraceKey ctxKeyTrace = 0
+
+// Trace is a W3C Trace Context. SpanID is the span of this server, ParentID the span of the caller, if any.
+type Trace struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Flags    byte
+	State    string
+}
+
+func (t Trace) Sampled() bool {
+	return t.Flags&0x01 != 0
+}
+
+// TraceParent returns the traceparent header to propagate t to a downstream service.
+func (t Trace) TraceParent() string {
+	return "00-" + t.TraceID + "-" + t.SpanID + "-" + hex.EncodeToString([]byte{t.Flags})
+}
+
+type TraceConfig struct {
+	// Sampled is the sampled flag of the traces started by this server, for requests without a valid traceparent.
+	Sampled bool `yaml:"sampled" mapstructure:"sampled" json:"sampled,omitempty" gorm:"column:sampled" bson:"sampled,omitempty" dynamodbav:"sampled,omitempty" firestore:"sampled,omitempty"`
+	// Response is the header that echoes the traceparent of this server, none by default.
+	Response string `yaml:"response" mapstructure:"response" json:"response,omitempty" gorm:"column:response" bson:"response,omitempty" dynamodbav:"response,omitempty" firestore:"response,omitempty"`
+}
+
+// TraceContext stores the Trace of the request under TraceKey, continuing the trace of its traceparent header.
+func TraceContext(conf TraceConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r := c.Request()
+			t := BuildTrace(r, conf)
+			if len(conf.Response) > 0 {
+				c.Response().Header().Set(conf.Response, t.TraceParent())
+			}
+			ctx := context.WithValue(r.Context(), TraceKey, t)
+			c.SetRequest(r.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// BuildTrace continues the trace of the traceparent and tracestate headers of r with a new span,
+// or starts a new trace if traceparent is missing or invalid.
+func BuildTrace(r *http.Request, c TraceConfig) Trace {
+	t, ok := ParseTraceParent(r.Header.Get(TraceParentHeader))
+	if !ok {
+		t = Trace{TraceID: NewTraceID(), SpanID: NewSpanID()}
+		if c.Sampled {
+			t.Flags = 0x01
+		}
+		return t
+	}
+	t.ParentID = t.SpanID
+	t.SpanID = NewSpanID()
+	t.State = strings.Join(r.Header.Values(TraceStateHeader), ",")
+	return t
+}
+
+// ParseTraceParent parses a traceparent header: version, trace ID, parent ID and flags, in lowercase hex.
+// The IDs must not be all zeros, and version "ff" is invalid. Versions above "00" may have more fields.
+func ParseTraceParent(s string) (Trace, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return Trace{}, false
+	}
+	version := s[0:2]
+	if !isLowerHex(version) || version == "ff" {
+		return Trace{}, false
+	}
+	if len(s) > 55 && (version == "00" || s[55] != '-') {
+		return Trace{}, false
+	}
+	traceID, parentID, flags := s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(traceID) || !isLowerHex(parentID) || !isLowerHex(flags) || isZeroHex(traceID) || isZeroHex(parentID) {
+		return Trace{}, false
+	}
+	b, _ := hex.DecodeString(flags)
+	return Trace{TraceID: traceID, SpanID: parentID, Flags: b[0]}, true
+}
+
+// GetTrace returns the Trace of the given context, if one is present.
+func GetTrace(ctx context.Context) (Trace, bool) {
+	if ctx == nil {
+		return Trace{}, false
+	}
+	t, ok := ctx.Value(TraceKey).(Trace)
+	return t, ok
+}
+
+// InjectTrace sets the traceparent and tracestate headers of an outgoing request from the Trace of ctx.
+func InjectTrace(ctx context.Context, header http.Header) {
+	t, ok := GetTrace(ctx)
+	if !ok {
+		return
+	}
+	header.Set(TraceParentHeader, t.TraceParent())
+	if len(t.State) > 0 {
+		header.Set(TraceStateHeader, t.State)
+	}
+}
+
+// BuildTraceFields adds the trace ID, span ID and flags of the Trace of r to the configured fields.
+func BuildTraceFields(c LogConfig, r *http.Request, fields map[string]interface{}) {
+	if len(c.TraceId) == 0 && len(c.SpanId) == 0 && len(c.TraceFlags) == 0 {
+		return
+	}
+	t, ok := GetTrace(r.Context())
+	if !ok {
+		return
+	}
+	if len(c.TraceId) > 0 {
+		fields[c.TraceId] = t.TraceID
+	}
+	if len(c.SpanId) > 0 {
+		fields[c.SpanId] = t.SpanID
+	}
+	if len(c.TraceFlags) > 0 {
+		fields[c.TraceFlags] = hex.EncodeToString([]byte{t.Flags})
+	}
+}
+
+// NewTraceID returns a random 16 byte trace ID in lowercase hex.
+func NewTraceID() string {
+	return newHexID(16)
+}
+
+// NewSpanID returns a random 8 byte span ID in lowercase hex.
+func NewSpanID() string {
+	return newHexID(8)
+}
+
+func newHexID(n int) string {
+	b := make([]byte, n)
+	for {
+		rand.Read(b)
+		for _, x := range b {
+			if x != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func isZeroHex(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
@@ -0,0 +1,93 @@
+package gin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	IpTruncate = "truncate"
+	IpHmac     = "hmac"
+	IpDrop     = "drop"
+)
+
+// IpConfig anonymizes the client IPs of the log fields and context values; handlers still see the full r.RemoteAddr.
+// IpMode is "truncate" (to IpV4Prefix bits, 24 by default, or IpV6Prefix bits, 48 by default),
+// "hmac" (a token keyed by IpKey, the same for the same IP) or "drop". IPs are kept if IpMode is empty.
+type IpConfig struct {
+	IpMode     string `yaml:"ip_mode" mapstructure:"ip_mode" json:"ipMode,omitempty" gorm:"column:ipmode" bson:"ipMode,omitempty" dynamodbav:"ipMode,omitempty" firestore:"ipMode,omitempty"`
+	IpV4Prefix int    `yaml:"ip_v4_prefix" mapstructure:"ip_v4_prefix" json:"ipV4Prefix,omitempty" gorm:"column:ipv4prefix" bson:"ipV4Prefix,omitempty" dynamodbav:"ipV4Prefix,omitempty" firestore:"ipV4Prefix,omitempty"`
+	IpV6Prefix int    `yaml:"ip_v6_prefix" mapstructure:"ip_v6_prefix" json:"ipV6Prefix,omitempty" gorm:"column:ipv6prefix" bson:"ipV6Prefix,omitempty" dynamodbav:"ipV6Prefix,omitempty" firestore:"ipV6Prefix,omitempty"`
+	IpKey      string `yaml:"ip_key" mapstructure:"ip_key" json:"ipKey,omitempty" gorm:"column:ipkey" bson:"ipKey,omitempty" dynamodbav:"ipKey,omitempty" firestore:"ipKey,omitempty"`
+}
+
+// CompileIpConfig validates c and sets its default prefixes.
+func CompileIpConfig(c IpConfig) (IpConfig, error) {
+	c.IpMode = strings.ToLower(c.IpMode)
+	switch c.IpMode {
+	case "", IpDrop:
+	case IpTruncate:
+		c.IpV4Prefix = ipPrefix(c.IpV4Prefix, 24)
+		c.IpV6Prefix = ipPrefix(c.IpV6Prefix, 48)
+		if c.IpV4Prefix > 32 || c.IpV6Prefix > 128 {
+			return c, fmt.Errorf("invalid ip prefixes /%d and /%d", c.IpV4Prefix, c.IpV6Prefix)
+		}
+	case IpHmac:
+		if len(c.IpKey) == 0 {
+			return c, fmt.Errorf("ip mode %q requires an ip key", c.IpMode)
+		}
+	default:
+		return c, fmt.Errorf("invalid ip mode %q", c.IpMode)
+	}
+	return c, nil
+}
+
+// Anonymize returns ip as configured by c, or false if it must be dropped.
+func (c IpConfig) Anonymize(ip string) (string, bool) {
+	switch strings.ToLower(c.IpMode) {
+	case IpDrop:
+		return "", false
+	case IpHmac:
+		mac := hmac.New(sha256.New, []byte(c.IpKey))
+		mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil)[:16]), true
+	case IpTruncate:
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return ip, true
+		}
+		if ip4 := parsed.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(ipPrefix(c.IpV4Prefix, 24), 32)).String(), true
+		}
+		return parsed.Mask(net.CIDRMask(ipPrefix(c.IpV6Prefix, 48), 128)).String(), true
+	default:
+		return ip, true
+	}
+}
+
+// AnonymizeAddr applies Anonymize to the host of addr, keeping its port.
+func (c IpConfig) AnonymizeAddr(addr string) (string, bool) {
+	if len(c.IpMode) == 0 {
+		return addr, true
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return c.Anonymize(addr)
+	}
+	host, ok := c.Anonymize(host)
+	if !ok {
+		return "", false
+	}
+	return net.JoinHostPort(host, port), true
+}
+
+func ipPrefix(prefix int, defaultPrefix int) int {
+	if prefix <= 0 {
+		return defaultPrefix
+	}
+	return prefix
+}
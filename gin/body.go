@@ -0,0 +1,192 @@
+package gin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// TruncatedBody is logged instead of the body string when a request or response body exceeds its capture limit.
+// Size is the original size in bytes, or -1 if it is unknown.
+type TruncatedBody struct {
+	Body      string `json:"body"`
+	Truncated bool   `json:"truncated"`
+	Size      int64  `json:"size"`
+}
+
+// BinaryBody is logged instead of a body whose content type is not captured.
+// Size is -1 if it is unknown. Hash is the hex sha256 of the body, if LogConfig.BodyHash is set.
+type BinaryBody struct {
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size"`
+	Hash        string `json:"hash,omitempty"`
+}
+
+// RequestBody replaces the request body when it is captured with a limit or hashed.
+// The handler still reads the full stream: the captured bytes first, then the rest of the original body.
+type RequestBody struct {
+	io.Reader
+	body  io.ReadCloser
+	hash  hash.Hash
+	eof   bool
+	Bytes int64
+	// Elapsed is the time spent in Read.
+	Elapsed time.Duration
+}
+
+func (b *RequestBody) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := b.Reader.Read(p)
+	b.Elapsed += time.Since(start)
+	b.Bytes += int64(n)
+	if b.hash != nil {
+		b.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+func (b *RequestBody) Close() error {
+	return b.body.Close()
+}
+
+// BuildRequestBodyWithLimit captures at most limit bytes of the request body into fields[request].
+// If the body is larger, the value is a TruncatedBody. A limit <= 0 captures the whole body.
+func BuildRequestBodyWithLimit(r *http.Request, request string, limit int, fields map[string]interface{}) {
+	if limit <= 0 {
+		BuildRequest(r, request, fields)
+		return
+	}
+	if len(request) == 0 || r.Body == nil {
+		return
+	}
+	buf := new(bytes.Buffer)
+	n, _ := buf.ReadFrom(io.LimitReader(r.Body, int64(limit)+1))
+	if n <= int64(limit) {
+		fields[request] = buf.String()
+		r.Body = io.NopCloser(buf)
+		return
+	}
+	captured := buf.Bytes()
+	fields[request] = TruncatedBody{Body: string(captured[:limit]), Truncated: true, Size: r.ContentLength}
+	r.Body = &RequestBody{Reader: io.MultiReader(bytes.NewReader(captured), r.Body), body: r.Body}
+}
+
+// BuildBinaryRequestBody logs a BinaryBody into fields[request] without reading the body.
+// If h is not nil, the body is hashed while the handler reads it.
+func BuildBinaryRequestBody(r *http.Request, request string, h hash.Hash, fields map[string]interface{}) {
+	if len(request) == 0 || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	fields[request] = BinaryBody{ContentType: r.Header.Get("Content-Type"), Size: r.ContentLength}
+	if h != nil {
+		r.Body = &RequestBody{Reader: r.Body, body: r.Body, hash: h}
+	}
+}
+
+// UpdateRequestSize completes a truncated or binary request body after the handler has read it:
+// the size if it was unknown, and the hash if the body was read to the end.
+func UpdateRequestSize(r *http.Request, request string, fields map[string]interface{}) {
+	if len(request) == 0 {
+		return
+	}
+	rb, ok := r.Body.(*RequestBody)
+	if !ok {
+		return
+	}
+	switch body := fields[request].(type) {
+	case TruncatedBody:
+		if body.Size < 0 {
+			body.Size = rb.Bytes
+			fields[request] = body
+		}
+	case BinaryBody:
+		if body.Size < 0 {
+			body.Size = rb.Bytes
+		}
+		if rb.hash != nil && rb.eof {
+			body.Hash = hex.EncodeToString(rb.hash.Sum(nil))
+		}
+		fields[request] = body
+	}
+}
+
+// BuildBinaryResponseBody logs a BinaryBody into fields[c.Response] if the response content type is not captured,
+// and returns c without the Response key, so that the Formatter does not log the body again.
+func BuildBinaryResponseBody(c LogConfig, types []string, contentType string, size int, h hash.Hash, fields map[string]interface{}) LogConfig {
+	if len(c.Response) == 0 || size == 0 || CaptureResponseType(contentType, types) {
+		return c
+	}
+	body := BinaryBody{ContentType: contentType, Size: int64(size)}
+	if h != nil {
+		body.Hash = hex.EncodeToString(h.Sum(nil))
+	}
+	fields[c.Response] = body
+	c.Response = ""
+	return c
+}
+
+// CaptureMethod reports whether the request body of method is captured.
+// Without configured methods, the bodies of all methods except GET and DELETE are captured.
+func CaptureMethod(method string, methods []string) bool {
+	if len(methods) == 0 {
+		return method != "GET" && method != "DELETE"
+	}
+	for _, m := range methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// CaptureRequestType reports whether a request body of contentType is captured.
+// Without configured types, all request bodies except multipart/form-data are captured.
+func CaptureRequestType(contentType string, types []string) bool {
+	if len(types) == 0 {
+		return !strings.Contains(contentType, "multipart/form-data")
+	}
+	return MatchContentType(contentType, types)
+}
+
+// CaptureResponseType reports whether a response body of contentType is captured.
+// Without configured types, all response bodies are captured.
+func CaptureResponseType(contentType string, types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	return MatchContentType(contentType, types)
+}
+
+// MatchContentType matches the media type of contentType, without parameters, against patterns
+// such as "application/json", "text/*" or "application/*+json".
+func MatchContentType(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(strings.TrimSpace(pattern)), mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseContentType returns the Content-Type header of the response, or the type detected from the captured body.
+func ResponseContentType(header http.Header, body []byte) string {
+	if contentType := header.Get("Content-Type"); len(contentType) > 0 {
+		return contentType
+	}
+	if len(body) == 0 {
+		return ""
+	}
+	return http.DetectContentType(body)
+}
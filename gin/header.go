@@ -0,0 +1,68 @@
+package gin
+
+import (
+	"net/http"
+	"strings"
+)
+
+const DefaultRedaction = "***"
+
+// RedactedHeaders are always redacted when headers are logged.
+var RedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// HeaderConfig logs the request headers into the Request field and the response headers into the Response field.
+// If Allow is set, only these headers are logged. Deny headers are never logged.
+// The values of RedactedHeaders and Redact headers are replaced by Redaction, "***" by default.
+// With Flatten, each header is logged as a "<field>.<header>" key, otherwise as a nested map.
+type HeaderConfig struct {
+	Request   string   `yaml:"request" mapstructure:"request" json:"request,omitempty" gorm:"column:request" bson:"request,omitempty" dynamodbav:"request,omitempty" firestore:"request,omitempty"`
+	Response  string   `yaml:"response" mapstructure:"response" json:"response,omitempty" gorm:"column:response" bson:"response,omitempty" dynamodbav:"response,omitempty" firestore:"response,omitempty"`
+	Allow     []string `yaml:"allow" mapstructure:"allow" json:"allow,omitempty" gorm:"column:allow" bson:"allow,omitempty" dynamodbav:"allow,omitempty" firestore:"allow,omitempty"`
+	Deny      []string `yaml:"deny" mapstructure:"deny" json:"deny,omitempty" gorm:"column:deny" bson:"deny,omitempty" dynamodbav:"deny,omitempty" firestore:"deny,omitempty"`
+	Redact    []string `yaml:"redact" mapstructure:"redact" json:"redact,omitempty" gorm:"column:redact" bson:"redact,omitempty" dynamodbav:"redact,omitempty" firestore:"redact,omitempty"`
+	Redaction string   `yaml:"redaction" mapstructure:"redaction" json:"redaction,omitempty" gorm:"column:redaction" bson:"redaction,omitempty" dynamodbav:"redaction,omitempty" firestore:"redaction,omitempty"`
+	Flatten   bool     `yaml:"flatten" mapstructure:"flatten" json:"flatten,omitempty" gorm:"column:flatten" bson:"flatten,omitempty" dynamodbav:"flatten,omitempty" firestore:"flatten,omitempty"`
+}
+
+// BuildHeaders adds the headers allowed by c into fields[field].
+func BuildHeaders(header http.Header, field string, c *HeaderConfig, fields map[string]interface{}) {
+	if c == nil || len(field) == 0 || len(header) == 0 {
+		return
+	}
+	var m map[string]string
+	if !c.Flatten {
+		m = make(map[string]string)
+	}
+	for name, values := range header {
+		if len(c.Allow) > 0 && !containsHeader(c.Allow, name) {
+			continue
+		}
+		if containsHeader(c.Deny, name) {
+			continue
+		}
+		value := strings.Join(values, ", ")
+		if containsHeader(RedactedHeaders, name) || containsHeader(c.Redact, name) {
+			value = c.Redaction
+			if len(value) == 0 {
+				value = DefaultRedaction
+			}
+		}
+		if c.Flatten {
+			fields[field+"."+strings.ToLower(name)] = value
+		} else {
+			m[strings.ToLower(name)] = value
+		}
+	}
+	if !c.Flatten && len(m) > 0 {
+		fields[field] = m
+	}
+}
+
+func containsHeader(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(strings.TrimSpace(n), name) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"io"
+	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,53 +19,229 @@ type GinLogger struct {
 	LogInfo func(ctx context.Context, msg string, fields map[string]interface{})
 	f       Formatter
 	Mask    func(fieldName, s string) string
+	// Levels are the log functions of the levels of LogConfig.Levels, LogInfo is used for the missing ones.
+	Levels LevelLogger
+
+	// Holder, if set, replaces Config: the loggers reload it on each request.
+	Holder      *ConfigHolder
+	fieldConfig *FieldConfig
+	debugErrors int64
+	queue       *LogQueue
 }
 
 func NewGinLogger(c LogConfig, logInfo func(ctx context.Context, msg string, fields map[string]interface{}), f Formatter, mask func(fieldName, s string) string) *GinLogger {
-	return &GinLogger{c, logInfo, f, mask}
+	fc := NewFieldConfig(c)
+	// the log fields resolve the client IP with the compiled trusted proxies
+	c.Proxy = fc.Proxy
+	l := &GinLogger{Config: c, LogInfo: logInfo, f: f, Mask: mask, fieldConfig: &fc}
+	if c.Async != nil {
+		l.queue = NewLogQueue(*c.Async)
+	}
+	return l
+}
+
+func NewGinLoggerWithLevels(c LogConfig, logs LevelLogger, f Formatter, mask func(fieldName, s string) string) *GinLogger {
+	l := NewGinLogger(c, logs.Info, f, mask)
+	l.Levels = logs
+	return l
+}
+
+// getFieldConfig compiles Config on first use, for loggers not created by NewGinLogger.
+func (l *GinLogger) getFieldConfig() *FieldConfig {
+	if l.fieldConfig == nil {
+		fc := NewFieldConfig(l.Config)
+		l.fieldConfig = &fc
+	}
+	return l.fieldConfig
+}
+
+// load returns the config of a request: the current config of Holder if it is set.
+func (l *GinLogger) load() (LogConfig, *FieldConfig) {
+	if l.Holder != nil {
+		return l.Holder.Load()
+	}
+	return l.Config, l.fieldConfig
+}
+
+// debug applies the debug token of r, counting the invalid ones.
+func (l *GinLogger) debug(r *http.Request, c LogConfig, fc *FieldConfig) (*http.Request, LogConfig, *FieldConfig) {
+	dr, dc, dfc, err := DebugRequest(r, c, fc)
+	if err != nil {
+		atomic.AddInt64(&l.debugErrors, 1)
+	}
+	return dr, dc, dfc
+}
+
+// DebugErrors returns the number of invalid or expired debug tokens received.
+func (l *GinLogger) DebugErrors() int64 {
+	return atomic.LoadInt64(&l.debugErrors)
+}
+
+func (l *GinLogger) logFunc(level string) func(ctx context.Context, msg string, fields map[string]interface{}) {
+	if fn := l.Levels.Func(level); fn != nil {
+		return fn
+	}
+	return l.LogInfo
+}
+
+func (l *GinLogger) getQueue() *LogQueue {
+	if l.queue == nil && l.Config.Async != nil {
+		l.queue = NewLogQueue(*l.Config.Async)
+	}
+	return l.queue
+}
+
+// Flush waits until the queued records are written, if Config.Async is set.
+func (l *GinLogger) Flush(ctx context.Context) error {
+	if l.queue == nil {
+		return nil
+	}
+	return l.queue.Flush(ctx)
+}
+
+// Shutdown drains the queued records and stops the workers, if Config.Async is set.
+func (l *GinLogger) Shutdown(ctx context.Context) error {
+	if l.queue == nil {
+		return nil
+	}
+	return l.queue.Shutdown(ctx)
+}
+
+// Dropped returns the number of records dropped by the queue, if Config.Async is set.
+func (l *GinLogger) Dropped() int64 {
+	if l.queue == nil {
+		return 0
+	}
+	return l.queue.Dropped()
+}
+
+// Pending returns the number of records queued or being written, if Config.Async is set.
+func (l *GinLogger) Pending() int64 {
+	if l.queue == nil {
+		return 0
+	}
+	return l.queue.Pending()
 }
 
 func (l *GinLogger) Logger() gin.HandlerFunc {
-	InitializeFieldConfig(l.Config)
+	// compile Config before serving concurrent requests
+	l.getFieldConfig()
+	q := l.getQueue()
 	return func(c *gin.Context) {
-		if !fieldConfig.Log || InSkipList(c.Request, fieldConfig.Skips) {
+		lc, fc := l.load()
+		if route := c.FullPath(); len(route) > 0 {
+			c.Request = WithRoute(c.Request, route)
+		}
+		lc, fc = CaptureBodies(c.Request, lc, fc)
+		c.Request, lc, fc = l.debug(c.Request, lc, fc)
+		if !fc.Log || InSkipList(c.Request, fc.Skips) || InSkipRules(c.Request, fc.SkipRules) {
 			c.Next()
 		} else {
 			r := c.Request
-			dw := NewResponseWriter(c.Writer)
+			dw := NewResponseWriter(c.Writer, DeferredLimit(lc.ResponseLimit, fc.Deferred))
+			dw.Hash = newHash(lc)
 
 			startTime := time.Now()
-			fields := BuildLogFields(l.Config, r)
-			includeRequest := !l.Config.Separate
-			if r.Method == "GET" || r.Method == "DELETE" || strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
+			fields := BuildLogFieldsWithMask(lc, r, l.Mask)
+			rate, sampled, byTrace := 1.0, true, false
+			if fc.Sampling != nil {
+				rate, sampled, byTrace = HeadSample(r, fc.Sampling)
+				BuildSampleFields(fc.Sampling, rate, byTrace, fields)
+			}
+			includeRequest := !lc.Separate || !sampled || fc.Deferred != nil
+			readStart := time.Now()
+			var slowBody *SlowBody
+			if !CaptureMethod(r.Method, fc.BodyMethods) {
 				includeRequest = true
+				slowBody = CaptureSlowRequest(r, fc.Slow, fc.BodyTypes)
+			} else if CaptureRequestType(r.Header.Get("Content-Type"), fc.BodyTypes) {
+				BuildRequestBodyWithLimit(r, lc.Request, DeferredLimit(lc.RequestLimit, fc.Deferred), fields)
+				if len(lc.Request) == 0 {
+					slowBody = CaptureSlowRequest(r, fc.Slow, fc.BodyTypes)
+				}
 			} else {
-				BuildRequest(r, l.Config.Request, fields)
+				includeRequest = true
+				BuildBinaryRequestBody(r, lc.Request, newHash(lc), fields)
+			}
+			read := time.Since(readStart)
+			if len(lc.ReadTime) > 0 {
+				TimeRequestBody(r)
 			}
 			if !includeRequest {
-				go l.f.LogRequest(l.LogInfo, r, fields)
+				level := RequestLevel(lc.Levels)
+				log, lr := l.logFunc(level), BuildLogRequest(r, lc, level, l.Mask)
+				Dispatch(q, func() {
+					l.f.LogRequest(log, lr, fields)
+				})
 			}
 			c.Writer = dw
+			completed := false
 			defer func() {
+				elapsed := time.Since(startTime)
+				slow := IsSlow(r, fc.Slow, elapsed)
+				if fc.Sampling != nil {
+					if slow || TailSample(fc.Sampling, dw.Status(), elapsed) {
+						rate, byTrace = 1, false
+					} else if !sampled {
+						return
+					}
+				}
+				resFields := fields
 				if includeRequest {
-					go l.f.LogResponse(l.LogInfo, r, *dw, l.Config, startTime, dw.Body.String(), fields, includeRequest)
+					UpdateRequestSize(r, lc.Request, fields)
 				} else {
-					resFields := BuildLogFields(l.Config, r)
-					go l.f.LogResponse(l.LogInfo, r, *dw, l.Config, startTime, dw.Body.String(), resFields, includeRequest)
+					resFields = BuildLogFieldsWithMask(lc, r, l.Mask)
+				}
+				if fc.Sampling != nil {
+					BuildSampleFields(fc.Sampling, rate, byTrace, resFields)
+				}
+				BuildTimingFields(lc, r, startTime, read, dw.Timing, resFields)
+				BuildRouteField(lc, GetRoute(r), resFields)
+				BuildServerTimingFields(lc, r, resFields)
+				rc, response := lc, dw.Body.String()
+				if slow {
+					rc, response = EscalateSlow(lc, fc.Slow, r, slowBody, response, resFields)
+				}
+				if fc.Deferred != nil {
+					if KeepBodies(fc.Deferred, dw.Status(), !completed || len(c.Errors) > 0) {
+						rc.ResponseLimit = DeferredLimit(rc.ResponseLimit, fc.Deferred)
+					} else {
+						rc = DiscardBodies(rc, resFields)
+					}
+				}
+				contentType := ResponseContentType(dw.Header(), dw.Body.Bytes())
+				rc = BuildBinaryResponseBody(rc, fc.BodyTypes, contentType, dw.Size(), dw.Hash, resFields)
+				level := ResponseLevel(lc.Levels, dw.Status(), elapsed, !completed)
+				if slow {
+					level = MaxLevel(level, fc.Slow.Level)
 				}
+				log, lr := l.logFunc(level), BuildLogRequest(r, lc, level, l.Mask)
+				// shift startTime, so that the duration logged by the formatter excludes the time spent in the queue
+				Dispatch(q, func() {
+					l.f.LogResponse(log, lr, *dw, rc, time.Now().Add(-elapsed), response, resFields, includeRequest || slow)
+				})
 			}()
 			c.Next()
+			completed = true
 		}
 	}
 }
 
 func (l *GinLogger) BuildContextWithMask() gin.HandlerFunc {
+	// compile Config before serving concurrent requests
+	l.getFieldConfig()
 	return func(c *gin.Context) {
+		_, fc := l.load()
 		ctxGin := c
 		var ctx context.Context
 		ctx = c.Request.Context()
-		if fieldConfig.Constants != nil && len(fieldConfig.Constants) > 0 {
-			for k, e := range fieldConfig.Constants {
+		if len(fc.Ip) > 0 {
+			if ip, ok := fc.Anonymize(ClientIP(c.Request, fc.Proxy)); ok {
+				ctx = context.WithValue(ctx, fc.Ip, ip)
+			}
+		}
+		if fc.Constants != nil && len(fc.Constants) > 0 {
+			for k, e := range fc.Constants {
 				if len(e) > 0 {
 					ctx = context.WithValue(ctx, k, e)
 				}
@@ -71,47 +249,47 @@ func (l *GinLogger) BuildContextWithMask() gin.HandlerFunc {
 		}
 
 		r := c.Request
-		if fieldConfig.Headers != nil && len(fieldConfig.Headers) > 0 {
-			for k, e := range fieldConfig.Headers {
+		if fc.Headers != nil && len(fc.Headers) > 0 {
+			for k, e := range fc.Headers {
 				if len(e) > 0 {
 					header := r.Header.Get(e)
 					ctx = context.WithValue(ctx, k, header)
 				}
 			}
 		}
-		if fieldConfig.Map != nil && len(fieldConfig.Map) > 0 && r.Body != nil && (r.Method != "GET" || r.Method != "DELETE") {
+		if fc.Map != nil && len(fc.Map) > 0 && r.Body != nil && (r.Method != "GET" && r.Method != "DELETE") {
 			buf := new(bytes.Buffer)
 			buf.ReadFrom(r.Body)
 			r.Body = io.NopCloser(buf)
 			var v interface{}
 			er2 := json.NewDecoder(strings.NewReader(buf.String())).Decode(&v)
 			if er2 != nil {
-				if len(fieldConfig.Ip) == 0 && fieldConfig.Constants == nil {
+				if len(fc.Ip) == 0 && fc.Constants == nil {
 					c.Next()
 				} else {
 
-					ctxGin.Request.WithContext(ctx)
+					ctxGin.Request = ctxGin.Request.WithContext(ctx)
 					ctxGin.Next()
 				}
 			} else {
 				m, ok := v.(map[string]interface{})
 				if !ok {
-					if len(fieldConfig.Ip) == 0 && fieldConfig.Constants == nil {
+					if len(fc.Ip) == 0 && fc.Constants == nil {
 						c.Next()
 					} else {
-						ctxGin.Request.WithContext(ctx)
+						ctxGin.Request = ctxGin.Request.WithContext(ctx)
 						ctxGin.Next()
 					}
 				} else {
-					for k, e := range fieldConfig.Map {
+					for k, e := range fc.Map {
 						if strings.Index(e, ".") >= 0 {
 							v3 := ValueOf(v, e)
 							if v3 != nil {
 								s3, ok3 := v3.(string)
 								if ok3 {
 									if len(s3) > 0 {
-										if l.Mask != nil && fieldConfig.Masks != nil && len(fieldConfig.Masks) > 0 {
-											if Include(fieldConfig.Masks, k) {
+										if l.Mask != nil && fc.Masks != nil && len(fc.Masks) > 0 {
+											if Include(fc.Masks, k) {
 												ctx = context.WithValue(ctx, k, l.Mask(k, s3))
 											} else {
 												ctx = context.WithValue(ctx, k, s3)
@@ -130,8 +308,8 @@ func (l *GinLogger) BuildContextWithMask() gin.HandlerFunc {
 								s3, ok3 := x.(string)
 								if ok3 {
 									if len(s3) > 0 {
-										if l.Mask != nil && fieldConfig.Masks != nil && len(fieldConfig.Masks) > 0 {
-											if Include(fieldConfig.Masks, k) {
+										if l.Mask != nil && fc.Masks != nil && len(fc.Masks) > 0 {
+											if Include(fc.Masks, k) {
 												ctx = context.WithValue(ctx, k, l.Mask(k, s3))
 											} else {
 												ctx = context.WithValue(ctx, k, s3)
@@ -151,7 +329,7 @@ func (l *GinLogger) BuildContextWithMask() gin.HandlerFunc {
 				}
 			}
 		} else {
-			if len(fieldConfig.Ip) == 0 && fieldConfig.Constants == nil && fieldConfig.Headers == nil {
+			if len(fc.Ip) == 0 && fc.Constants == nil && fc.Headers == nil {
 				c.Next()
 			} else {
 				ctxGin.Request = ctxGin.Request.WithContext(ctx)
@@ -0,0 +1,292 @@
+package gin
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultMetricsNamespace = "http"
+	MetricsContentType      = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var (
+	DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	DefaultSizeBuckets    = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+)
+
+// MetricsConfig configures the HTTP metrics. Namespace prefixes the metric names, "http" by default.
+// The requests matching SkipRules, such as the metrics endpoint itself, are not recorded.
+type MetricsConfig struct {
+	Namespace      string        `yaml:"namespace" mapstructure:"namespace" json:"namespace,omitempty" gorm:"column:namespace" bson:"namespace,omitempty" dynamodbav:"namespace,omitempty" firestore:"namespace,omitempty"`
+	LatencyBuckets []float64     `yaml:"latency_buckets" mapstructure:"latency_buckets" json:"latencyBuckets,omitempty" gorm:"column:latencybuckets" bson:"latencyBuckets,omitempty" dynamodbav:"latencyBuckets,omitempty" firestore:"latencyBuckets,omitempty"`
+	SizeBuckets    []float64     `yaml:"size_buckets" mapstructure:"size_buckets" json:"sizeBuckets,omitempty" gorm:"column:sizebuckets" bson:"sizeBuckets,omitempty" dynamodbav:"sizeBuckets,omitempty" firestore:"sizeBuckets,omitempty"`
+	SkipRules      []PathPattern `yaml:"skip_rules" mapstructure:"skip_rules" json:"skipRules,omitempty" gorm:"column:skiprules" bson:"skipRules,omitempty" dynamodbav:"skipRules,omitempty" firestore:"skipRules,omitempty"`
+}
+
+// Metrics records request counts, in-flight requests, latencies and request and response sizes,
+// labelled by method, route template and status class, and serves them in the Prometheus text format.
+type Metrics struct {
+	namespace      string
+	latencyBuckets []float64
+	sizeBuckets    []float64
+	skipRules      []PathPattern
+	inFlight       int64
+	mu             sync.Mutex
+	series         map[metricKey]*metricSeries
+}
+
+type metricKey struct {
+	method string
+	route  string
+	status string
+}
+
+type metricSeries struct {
+	count        uint64
+	latency      histogram
+	requestSize  histogram
+	responseSize histogram
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+	for i, b := range buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// NewMetrics creates the metrics of c. It panics if c.SkipRules are invalid.
+func NewMetrics(c MetricsConfig) *Metrics {
+	rules, err := CompilePathPatterns(c.SkipRules)
+	if err != nil {
+		panic(err)
+	}
+	m := &Metrics{
+		namespace:      c.Namespace,
+		latencyBuckets: sortedBuckets(c.LatencyBuckets, DefaultLatencyBuckets),
+		sizeBuckets:    sortedBuckets(c.SizeBuckets, DefaultSizeBuckets),
+		skipRules:      rules,
+		series:         make(map[metricKey]*metricSeries),
+	}
+	if len(m.namespace) == 0 {
+		m.namespace = DefaultMetricsNamespace
+	}
+	return m
+}
+
+func sortedBuckets(buckets []float64, defaults []float64) []float64 {
+	if len(buckets) == 0 {
+		buckets = defaults
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+// Observe records a completed request. An empty route is recorded as "unknown".
+func (m *Metrics) Observe(method string, route string, status int, duration time.Duration, requestSize int64, responseSize int64) {
+	if len(route) == 0 {
+		route = "unknown"
+	}
+	key := metricKey{method: metricMethod(method), route: route, status: statusClass(status)}
+	m.mu.Lock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{}
+		m.series[key] = s
+	}
+	s.count++
+	s.latency.observe(m.latencyBuckets, duration.Seconds())
+	if requestSize < 0 {
+		requestSize = 0
+	}
+	s.requestSize.observe(m.sizeBuckets, float64(requestSize))
+	if responseSize < 0 {
+		responseSize = 0
+	}
+	s.responseSize.observe(m.sizeBuckets, float64(responseSize))
+	m.mu.Unlock()
+}
+
+// metricMethod keeps the label cardinality bounded: unknown methods are recorded as "OTHER".
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "OTHER"
+	}
+}
+
+func statusClass(status int) string {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 100 || status > 599 {
+		return "unknown"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", MetricsContentType)
+	m.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	keys := make([]metricKey, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	series := make([]metricSeries, len(keys))
+	for i, k := range keys {
+		s := m.series[k]
+		series[i] = metricSeries{count: s.count, latency: s.latency.copy(), requestSize: s.requestSize.copy(), responseSize: s.responseSize.copy()}
+	}
+	m.mu.Unlock()
+
+	var b bytes.Buffer
+	name := m.namespace + "_requests_total"
+	writeMetricHeader(&b, name, "Total number of HTTP requests.", "counter")
+	for i, k := range keys {
+		fmt.Fprintf(&b, "%s{%s} %d\n", name, k.labels(), series[i].count)
+	}
+	name = m.namespace + "_requests_in_flight"
+	writeMetricHeader(&b, name, "Number of HTTP requests being served.", "gauge")
+	fmt.Fprintf(&b, "%s %d\n", name, atomic.LoadInt64(&m.inFlight))
+	m.writeHistograms(&b, m.namespace+"_request_duration_seconds", "Duration of HTTP requests in seconds.", m.latencyBuckets, keys, series, func(s *metricSeries) *histogram { return &s.latency })
+	m.writeHistograms(&b, m.namespace+"_request_size_bytes", "Size of HTTP request bodies in bytes.", m.sizeBuckets, keys, series, func(s *metricSeries) *histogram { return &s.requestSize })
+	m.writeHistograms(&b, m.namespace+"_response_size_bytes", "Size of HTTP response bodies in bytes.", m.sizeBuckets, keys, series, func(s *metricSeries) *histogram { return &s.responseSize })
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func (h histogram) copy() histogram {
+	h.counts = append([]uint64(nil), h.counts...)
+	return h
+}
+
+func (m *Metrics) writeHistograms(b *bytes.Buffer, name string, help string, buckets []float64, keys []metricKey, series []metricSeries, get func(*metricSeries) *histogram) {
+	writeMetricHeader(b, name, help, "histogram")
+	for i, k := range keys {
+		h := get(&series[i])
+		labels := k.labels()
+		for j, bucket := range buckets {
+			var n uint64
+			if j < len(h.counts) {
+				n = h.counts[j]
+			}
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bucket), n)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+func writeMetricHeader(b *bytes.Buffer, name string, help string, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (k metricKey) labels() string {
+	return `method="` + escapeLabel(k.method) + `",route="` + escapeLabel(k.route) + `",status="` + escapeLabel(k.status) + `"`
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// requestSize returns the size of the request body: its Content-Length, or the bytes read by the handler if it is unknown.
+func requestSize(r *http.Request, body *countingBody) int64 {
+	if r.ContentLength >= 0 || body == nil {
+		return r.ContentLength
+	}
+	return body.n
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+func countBody(r *http.Request) *countingBody {
+	if r.ContentLength >= 0 || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body := &countingBody{ReadCloser: r.Body}
+	r.Body = body
+	return body
+}
+
+// Middleware records the metrics of the requests, labelled by the route template of gin.
+// A request whose handler panics is recorded as 5xx. Serve the metrics with gin.WrapH(m).
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := c.Request
+		if InSkipRules(r, m.skipRules) {
+			c.Next()
+			return
+		}
+		atomic.AddInt64(&m.inFlight, 1)
+		startTime := time.Now()
+		body := countBody(r)
+		completed := false
+		defer func() {
+			atomic.AddInt64(&m.inFlight, -1)
+			status := c.Writer.Status()
+			if !completed {
+				status = http.StatusInternalServerError
+			}
+			m.Observe(r.Method, c.FullPath(), status, time.Since(startTime), requestSize(r, body), int64(c.Writer.Size()))
+		}()
+		c.Next()
+		completed = true
+	}
+}
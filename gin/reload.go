@@ -0,0 +1,102 @@
+package gin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+type loadedConfig struct {
+	config LogConfig
+	fields *FieldConfig
+}
+
+// ConfigHolder holds a LogConfig that can be replaced at runtime. The loggers using it pick up the new config on the next request.
+// The async queue of a logger is not reloaded.
+type ConfigHolder struct {
+	value atomic.Value
+}
+
+// NewConfigHolder returns a holder of c, or an error if c is invalid.
+func NewConfigHolder(c LogConfig) (*ConfigHolder, error) {
+	h := &ConfigHolder{}
+	if err := h.Update(c); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Load returns the current config and its compiled FieldConfig, which must not be modified.
+func (h *ConfigHolder) Load() (LogConfig, *FieldConfig) {
+	loaded := h.value.Load().(*loadedConfig)
+	return loaded.config, loaded.fields
+}
+
+// Update validates c and replaces the current config with it. The current config is kept if c is invalid.
+func (h *ConfigHolder) Update(c LogConfig) error {
+	fc, err := CompileFieldConfig(c)
+	if err != nil {
+		return fmt.Errorf("invalid log config: %w", err)
+	}
+	c.Proxy = fc.Proxy
+	h.value.Store(&loadedConfig{config: c, fields: &fc})
+	return nil
+}
+
+// LoadFile reads the config from path, decoded by unmarshal, and updates the holder with it.
+// unmarshal is json.Unmarshal if nil; use yaml.Unmarshal for YAML files.
+func (h *ConfigHolder) LoadFile(path string, unmarshal func([]byte, interface{}) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return h.loadData(path, data, unmarshal)
+}
+
+func (h *ConfigHolder) loadData(path string, data []byte, unmarshal func([]byte, interface{}) error) error {
+	if unmarshal == nil {
+		unmarshal = json.Unmarshal
+	}
+	var c LogConfig
+	if err := unmarshal(data, &c); err != nil {
+		return fmt.Errorf("cannot decode log config %q: %w", path, err)
+	}
+	return h.Update(c)
+}
+
+// WatchFile checks path every interval and reloads the config when the file changes, until ctx is done.
+// It blocks, so run it in a goroutine. A file that cannot be read or is invalid is reported to onError, and the current config is kept.
+func (h *ConfigHolder) WatchFile(ctx context.Context, path string, interval time.Duration, unmarshal func([]byte, interface{}) error, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last, _ := os.ReadFile(path)
+	lastErr := ""
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		data, err := os.ReadFile(path)
+		if err == nil && bytes.Equal(data, last) {
+			continue
+		}
+		if err == nil {
+			last = data
+			err = h.loadData(path, data, unmarshal)
+		}
+		if err == nil {
+			lastErr = ""
+		} else if err.Error() != lastErr {
+			// report a persistent error once
+			lastErr = err.Error()
+			if onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package gin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRequestIDHeader    = "X-Request-ID"
+	DefaultRequestIDMaxLength = 128
+)
+
+type RequestIDConfig struct {
+	// Headers are the incoming headers to honour, in order, such as X-Request-ID or X-Correlation-ID.
+	Headers []string `yaml:"headers" mapstructure:"headers" json:"headers,omitempty" gorm:"column:headers" bson:"headers,omitempty" dynamodbav:"headers,omitempty" firestore:"headers,omitempty"`
+	// Response is the header that echoes the request ID, the first of Headers by default.
+	Response  string `yaml:"response" mapstructure:"response" json:"response,omitempty" gorm:"column:response" bson:"response,omitempty" dynamodbav:"response,omitempty" firestore:"response,omitempty"`
+	MaxLength int    `yaml:"max_length" mapstructure:"max_length" json:"maxLength,omitempty" gorm:"column:maxlength" bson:"maxLength,omitempty" dynamodbav:"maxLength,omitempty" firestore:"maxLength,omitempty"`
+}
+
+// RequestID stores the request ID under RequestIDKey and echoes it on the response header.
+// An incoming ID is used if it is valid, otherwise a new one is generated by NewRequestID.
+func RequestID(conf RequestIDConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := c.Request
+		id := BuildRequestID(r, conf)
+		c.Writer.Header().Set(ResponseRequestIDHeader(conf), id)
+		ctx := context.WithValue(r.Context(), RequestIDKey, id)
+		c.Request = r.WithContext(ctx)
+		c.Next()
+	}
+}
+
+func ResponseRequestIDHeader(c RequestIDConfig) string {
+	if len(c.Response) > 0 {
+		return c.Response
+	}
+	if len(c.Headers) > 0 {
+		return c.Headers[0]
+	}
+	return DefaultRequestIDHeader
+}
+
+// BuildRequestID returns the first valid request ID of the configured headers, or a new one.
+func BuildRequestID(r *http.Request, c RequestIDConfig) string {
+	headers := c.Headers
+	if len(headers) == 0 {
+		headers = []string{DefaultRequestIDHeader}
+	}
+	maxLength := c.MaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultRequestIDMaxLength
+	}
+	for _, header := range headers {
+		id := r.Header.Get(header)
+		if IsValidRequestID(id, maxLength) {
+			return id
+		}
+	}
+	return NewRequestID()
+}
+
+// IsValidRequestID accepts non-empty IDs up to maxLength made of letters, digits and "-_.:/+=",
+// so that a client cannot inject spaces, control characters or huge values into the logs.
+func IsValidRequestID(id string, maxLength int) bool {
+	if len(id) == 0 || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		switch {
+		case ch >= '0' && ch <= '9', ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z':
+		case ch == '-', ch == '_', ch == '.', ch == ':', ch == '/', ch == '+', ch == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var (
+	requestIDMu   sync.Mutex
+	lastRequestMs uint64
+	lastEntropy   [10]byte
+)
+
+// NewRequestID returns a 26 character ULID: a 48 bit millisecond timestamp followed by 80 random bits,
+// encoded in Crockford base32. IDs sort by creation time, and IDs created in the same millisecond
+// are kept in order by incrementing the random part.
+func NewRequestID() string {
+	ms := uint64(time.Now().UnixMilli())
+	var entropy [10]byte
+	requestIDMu.Lock()
+	if ms <= lastRequestMs {
+		ms = lastRequestMs
+		entropy = lastEntropy
+		for i := len(entropy) - 1; i >= 0; i-- {
+			entropy[i]++
+			if entropy[i] != 0 {
+				break
+			}
+		}
+	} else {
+		rand.Read(entropy[:])
+	}
+	lastRequestMs = ms
+	lastEntropy = entropy
+	requestIDMu.Unlock()
+
+	var b [16]byte
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+	copy(b[6:], entropy[:])
+
+	var dst [26]byte
+	// 128 bits are encoded as 26 groups of 5 bits, with 2 leading zero bits.
+	var acc uint64
+	bits := uint(2)
+	j := 0
+	for i := 0; i < 16; i++ {
+		acc = acc<<8 | uint64(b[i])
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			dst[j] = crockford[(acc>>bits)&0x1F]
+			j++
+		}
+	}
+	return string(dst[:])
+}
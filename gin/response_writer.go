@@ -3,23 +3,57 @@ package gin
 import (
 	"bytes"
 	"github.com/gin-gonic/gin"
+	"hash"
 )
 
 type ResponseWriter struct {
 	gin.ResponseWriter
 	Body *bytes.Buffer
+	// Limit is the maximum number of bytes kept in Body, 0 for no limit. All bytes are still written to the client.
+	Limit int
+	// Hash, if set, is written with all bytes of the response.
+	Hash hash.Hash
+	// Timing records when the first and the last bytes were written.
+	Timing *WriteTiming
 }
 
-func NewResponseWriter(rw gin.ResponseWriter) *ResponseWriter {
-	return &ResponseWriter{Body: bytes.NewBufferString(""), ResponseWriter: rw}
+func NewResponseWriter(rw gin.ResponseWriter, opts ...int) *ResponseWriter {
+	var limit int
+	if len(opts) > 0 {
+		limit = opts[0]
+	}
+	return &ResponseWriter{Body: bytes.NewBufferString(""), ResponseWriter: rw, Limit: limit, Timing: &WriteTiming{}}
 }
 
 func (w ResponseWriter) Write(b []byte) (int, error) {
-	w.Body.Write(b)
+	w.Timing.mark()
+	w.capture(b)
 	return w.ResponseWriter.Write(b)
 }
 
 func (w ResponseWriter) WriteString(s string) (int, error) {
-	w.Body.WriteString(s)
+	w.Timing.mark()
+	w.capture([]byte(s))
 	return w.ResponseWriter.WriteString(s)
 }
+
+func (w ResponseWriter) capture(b []byte) {
+	if w.Hash != nil {
+		w.Hash.Write(b)
+	}
+	if w.Limit <= 0 {
+		w.Body.Write(b)
+		return
+	}
+	if remaining := w.Limit - w.Body.Len(); remaining > 0 {
+		if len(b) > remaining {
+			b = b[:remaining]
+		}
+		w.Body.Write(b)
+	}
+}
+
+func (w ResponseWriter) WriteHeaderNow() {
+	w.Timing.mark()
+	w.ResponseWriter.WriteHeaderNow()
+}
@@ -0,0 +1,29 @@
+package gin
+
+import (
+	"context"
+	"net/http"
+)
+
+type ctxKeyRoute int
+
+// RouteKey is the key that holds the route template of a request, such as "/users/:id", in a request context.
+const RouteKey ctxKeyRoute = 0
+
+// WithRoute returns a shallow copy of r whose context holds route under RouteKey.
+func WithRoute(r *http.Request, route string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), RouteKey, route))
+}
+
+// GetRoute returns the route template of r, which the logger stores under RouteKey.
+func GetRoute(r *http.Request) string {
+	route, _ := r.Context().Value(RouteKey).(string)
+	return route
+}
+
+// BuildRouteField adds route into fields[c.Route], if both are set.
+func BuildRouteField(c LogConfig, route string, fields map[string]interface{}) {
+	if len(c.Route) > 0 && len(route) > 0 {
+		fields[c.Route] = route
+	}
+}
@@ -0,0 +1,266 @@
+//go:build go1.21
+
+package gin
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+const (
+	SlogRequestGroup  = "request"
+	SlogResponseGroup = "response"
+)
+
+// NewGinLoggerWithSlog creates a logger writing to logger, at the levels of c.Levels.
+// The fields are grouped with the current config of the logger, which is the config of Holder if it is set later.
+func NewGinLoggerWithSlog(c LogConfig, logger *slog.Logger, f Formatter, mask func(fieldName, s string) string) *GinLogger {
+	l := NewGinLoggerWithLevels(c, LevelLogger{}, f, mask)
+	l.Levels = newSlogLevelLogger(func() LogConfig {
+		lc, _ := l.load()
+		return lc
+	}, logger)
+	l.LogInfo = l.Levels.Info
+	return l
+}
+
+// NewGinLoggerWithSlogHandler creates a logger writing to h, at the levels of c.Levels.
+func NewGinLoggerWithSlogHandler(c LogConfig, h slog.Handler, f Formatter, mask func(fieldName, s string) string) *GinLogger {
+	return NewGinLoggerWithSlog(c, slog.New(h), f, mask)
+}
+
+// NewSlogLevelLogger returns the log functions of the four levels, writing the fields converted by SlogAttrs to logger.
+// The fields are always grouped with c: use NewSlogLevelLoggerWithHolder for a config reloaded by a ConfigHolder.
+func NewSlogLevelLogger(c LogConfig, logger *slog.Logger) LevelLogger {
+	return newSlogLevelLogger(func() LogConfig { return c }, logger)
+}
+
+// NewSlogLevelLoggerWithHolder is NewSlogLevelLogger grouping the fields with the current config of h at log time.
+func NewSlogLevelLoggerWithHolder(h *ConfigHolder, logger *slog.Logger) LevelLogger {
+	return newSlogLevelLogger(func() LogConfig {
+		c, _ := h.Load()
+		return c
+	}, logger)
+}
+
+func newSlogLevelLogger(load func() LogConfig, logger *slog.Logger) LevelLogger {
+	return LevelLogger{
+		Debug: slogFunc(load, logger, slog.LevelDebug),
+		Info:  slogFunc(load, logger, slog.LevelInfo),
+		Warn:  slogFunc(load, logger, slog.LevelWarn),
+		Error: slogFunc(load, logger, slog.LevelError),
+	}
+}
+
+func slogFunc(load func() LogConfig, logger *slog.Logger, level slog.Level) func(ctx context.Context, msg string, fields map[string]interface{}) {
+	return func(ctx context.Context, msg string, fields map[string]interface{}) {
+		logger.LogAttrs(ctx, level, msg, SlogAttrs(load(), fields)...)
+	}
+}
+
+// SlogAttrs converts the fields of a request log into attributes: the request fields of c are grouped under "request",
+// the response fields under "response", and the other fields are kept at the top level. Nested maps become groups.
+func SlogAttrs(c LogConfig, fields map[string]interface{}) []slog.Attr {
+	request := slogFieldSet(c.Uri, c.Query, c.Method, c.Scheme, c.Proto, c.UserAgent, c.RemoteAddr, c.RemoteIp, c.Request)
+	response := slogFieldSet(c.ResponseStatus, c.Size, c.Response, durationField(c))
+	var requestHeader, responseHeader string
+	if c.LogHeaders != nil {
+		requestHeader, responseHeader = c.LogHeaders.Request, c.LogHeaders.Response
+	}
+	var attrs, requestAttrs, responseAttrs []slog.Attr
+	for _, k := range sortedKeys(fields) {
+		attr := slogAttr(k, fields[k])
+		switch {
+		case request[k] || isHeaderField(k, requestHeader):
+			requestAttrs = append(requestAttrs, attr)
+		case response[k] || isHeaderField(k, responseHeader):
+			responseAttrs = append(responseAttrs, attr)
+		default:
+			attrs = append(attrs, attr)
+		}
+	}
+	if len(requestAttrs) > 0 {
+		attrs = append(attrs, slog.Attr{Key: SlogRequestGroup, Value: slog.GroupValue(requestAttrs...)})
+	}
+	if len(responseAttrs) > 0 {
+		attrs = append(attrs, slog.Attr{Key: SlogResponseGroup, Value: slog.GroupValue(responseAttrs...)})
+	}
+	return attrs
+}
+
+func slogFieldSet(names ...string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		if len(name) > 0 {
+			set[name] = true
+		}
+	}
+	return set
+}
+
+func isHeaderField(key string, field string) bool {
+	return len(field) > 0 && (key == field || strings.HasPrefix(key, field+"."))
+}
+
+func slogAttr(key string, value interface{}) slog.Attr {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		attrs := make([]slog.Attr, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			attrs = append(attrs, slogAttr(k, v[k]))
+		}
+		return slog.Attr{Key: key, Value: slog.GroupValue(attrs...)}
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		attrs := make([]slog.Attr, 0, len(v))
+		for _, k := range keys {
+			attrs = append(attrs, slog.String(k, v[k]))
+		}
+		return slog.Attr{Key: key, Value: slog.GroupValue(attrs...)}
+	default:
+		return slog.Any(key, value)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MapHandler is a slog.Handler writing to map-based log functions, so that they can be used with slog.
+// Groups are written as nested maps. Records below Level, "info" by default, are discarded.
+type MapHandler struct {
+	Log    func(ctx context.Context, msg string, fields map[string]interface{})
+	Levels LevelLogger
+	Level  slog.Leveler
+	fields map[string]interface{}
+	groups []string
+}
+
+// NewMapHandler creates a handler writing to the log functions of levels, or to log for the missing ones.
+func NewMapHandler(log func(ctx context.Context, msg string, fields map[string]interface{}), levels ...LevelLogger) *MapHandler {
+	h := &MapHandler{Log: log}
+	if len(levels) > 0 {
+		h.Levels = levels[0]
+	}
+	return h
+}
+
+func (h *MapHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	min := slog.LevelInfo
+	if h.Level != nil {
+		min = h.Level.Level()
+	}
+	return level >= min
+}
+
+func (h *MapHandler) Handle(ctx context.Context, r slog.Record) error {
+	log := h.Levels.Func(SlogLevel(r.Level))
+	if log == nil {
+		log = h.Log
+	}
+	if log == nil {
+		return nil
+	}
+	fields := copyFields(h.fields)
+	m := groupFields(fields, h.groups)
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(m, a)
+		return true
+	})
+	log(ctx, r.Message, fields)
+	return nil
+}
+
+func (h *MapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.fields = copyFields(h.fields)
+	m := groupFields(h2.fields, h.groups)
+	for _, a := range attrs {
+		addAttr(m, a)
+	}
+	return &h2
+}
+
+func (h *MapHandler) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return h
+	}
+	h2 := *h
+	h2.groups = append(append(make([]string, 0, len(h.groups)+1), h.groups...), name)
+	return &h2
+}
+
+// SlogLevel maps a slog level to "debug", "info", "warn" or "error".
+func SlogLevel(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+func addAttr(m map[string]interface{}, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() != slog.KindGroup {
+		m[a.Key] = a.Value.Any()
+		return
+	}
+	attrs := a.Value.Group()
+	if len(attrs) == 0 {
+		return
+	}
+	g := m
+	if len(a.Key) > 0 {
+		g = groupFields(m, []string{a.Key})
+	}
+	for _, ga := range attrs {
+		addAttr(g, ga)
+	}
+}
+
+// groupFields returns the nested map of groups in m, creating the missing ones.
+func groupFields(m map[string]interface{}, groups []string) map[string]interface{} {
+	for _, g := range groups {
+		sub, ok := m[g].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			m[g] = sub
+		}
+		m = sub
+	}
+	return m
+}
+
+func copyFields(m map[string]interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if sub, ok := v.(map[string]interface{}); ok {
+			fields[k] = copyFields(sub)
+		} else {
+			fields[k] = v
+		}
+	}
+	return fields
+}
@@ -42,11 +42,11 @@ func (l *StructuredLogger) LogResponse(log func(context.Context, string, map[str
 	msg := r.Method + " " + r.RequestURI
 	log(r.Context(), msg, fields)
 	if l.send != nil {
-		go Send(r.Context(), l.send, msg, fields, l.KeyMap)
+		Send(r.Context(), l.send, msg, fields, l.KeyMap)
 	}
 }
 func Send(ctx context.Context, send func(context.Context, []byte, map[string]string) error, msg string, fields map[string]interface{}, keyMap map[string]string) {
-	m2 := AddKeyFields(msg, fields, keyMap)
+	m2 := AddKeyFieldsWithLevel(msg, GetLevel(ctx), fields, keyMap)
 	b, err := json.Marshal(m2)
 	if err == nil {
 		send(ctx, b, nil)
@@ -69,13 +69,15 @@ func (l *StructuredLogger) LogRequest(log func(context.Context, string, map[stri
 	}
 	log(r.Context(), msg, fields)
 	if l.send != nil {
-		go Send(r.Context(), l.send, msg, fields, l.KeyMap)
+		Send(r.Context(), l.send, msg, fields, l.KeyMap)
 	}
 }
 
 func BuildResponse(ww ResponseWriter, c LogConfig, t1 time.Time, response string, fields map[string]interface{}, isJsonFormat bool) {
 	if len(c.Response) > 0 {
-		if isJsonFormat {
+		if size := ww.Size(); c.ResponseLimit > 0 && len(response) < size {
+			fields[c.Response] = TruncatedBody{Body: response, Truncated: true, Size: int64(size)}
+		} else if isJsonFormat {
 			responseBody := response
 			responseMap := map[string]interface{}{}
 			json.Unmarshal([]byte(responseBody), &responseMap)
@@ -101,14 +103,15 @@ func BuildResponse(ww ResponseWriter, c LogConfig, t1 time.Time, response string
 			}
 		}
 	}
+	if c.LogHeaders != nil {
+		BuildHeaders(ww.Header(), c.LogHeaders.Response, c.LogHeaders, fields)
+	}
 	if len(c.ResponseStatus) > 0 {
 		fields[c.ResponseStatus] = ww.Status()
 	}
-	if len(fieldConfig.Duration) > 0 {
-		t2 := time.Now()
-		duration := t2.Sub(t1)
-		fields[fieldConfig.Duration] = duration.Milliseconds()
-	}
+	t2 := time.Now()
+	duration := t2.Sub(t1)
+	fields[durationField(c)] = FormatDuration(duration, c.DurationUnit)
 	if len(c.Size) > 0 {
 		fields[c.Size] = ww.Size()
 	}
@@ -125,6 +128,9 @@ func BuildRequest(r *http.Request, request string, fields map[string]interface{}
 	}
 }
 func AddKeyFields(message string, m map[string]interface{}, keys map[string]string) map[string]interface{} {
+	return AddKeyFieldsWithLevel(message, LevelInfo, m, keys)
+}
+func AddKeyFieldsWithLevel(message string, lv string, m map[string]interface{}, keys map[string]string) map[string]interface{} {
 	level := "level"
 	t := "time"
 	msg := "msg"
@@ -144,7 +150,7 @@ func AddKeyFields(message string, m map[string]interface{}, keys map[string]stri
 		}
 	}
 	m[msg] = message
-	m[level] = "info"
+	m[level] = lv
 	m[t] = time.Now()
 	return m
 }
@@ -0,0 +1,75 @@
+package gin
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	UnitNanosecond  = "ns"
+	UnitMicrosecond = "us"
+	UnitMillisecond = "ms"
+	UnitSecond      = "s"
+)
+
+// WriteTiming records when the first and the last bytes of a response were written.
+type WriteTiming struct {
+	First time.Time
+	Last  time.Time
+}
+
+func (t *WriteTiming) mark() {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	if t.First.IsZero() {
+		t.First = now
+	}
+	t.Last = now
+}
+
+// FormatDuration converts d to unit: an int64 of "ns", "us" (or "µs") and "ms", or a float64 of "s".
+// An unknown or empty unit is "ms".
+func FormatDuration(d time.Duration, unit string) interface{} {
+	switch unit {
+	case UnitNanosecond:
+		return d.Nanoseconds()
+	case UnitMicrosecond, "µs":
+		return d.Microseconds()
+	case UnitSecond:
+		return d.Seconds()
+	default:
+		return d.Milliseconds()
+	}
+}
+
+// TimeRequestBody wraps the body of r into a RequestBody, which measures the time spent reading it.
+func TimeRequestBody(r *http.Request) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	if _, ok := r.Body.(*RequestBody); !ok {
+		r.Body = &RequestBody{Reader: r.Body, body: r.Body}
+	}
+}
+
+// BuildTimingFields adds the phases of a request to fields, in c.DurationUnit: the time spent reading the request body,
+// read before the handler plus the reads of the handler, and the times from startTime to the first and the last bytes of the response.
+func BuildTimingFields(c LogConfig, r *http.Request, startTime time.Time, read time.Duration, t *WriteTiming, fields map[string]interface{}) {
+	if len(c.ReadTime) > 0 {
+		if rb, ok := r.Body.(*RequestBody); ok {
+			read += rb.Elapsed
+		}
+		fields[c.ReadTime] = FormatDuration(read, c.DurationUnit)
+	}
+	if t == nil || t.First.IsZero() {
+		return
+	}
+	if len(c.FirstByte) > 0 {
+		fields[c.FirstByte] = FormatDuration(t.First.Sub(startTime), c.DurationUnit)
+	}
+	if len(c.LastByte) > 0 {
+		fields[c.LastByte] = FormatDuration(t.Last.Sub(startTime), c.DurationUnit)
+	}
+}
@@ -0,0 +1,384 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// JWTConfig maps the claims of the JWT of a request into context values and log fields.
+// The token is read from Header ("Authorization" by default, with an optional "Bearer " prefix), or else from Cookie.
+// Claims maps a context key to a claim, with "." for nested claims. Context, if set, is the context key of all the claims.
+// The token is verified with Secret (HMAC), KeyFile (PEM RSA or ECDSA public key, or certificate) or JWKSFile (local JWKS);
+// a token that fails verification, or has expired, is ignored. Algorithms restricts the accepted "alg" values.
+// Without keys, Unverified must be set to accept the tokens as they are. Any client can forge such claims, which then reach
+// the context values, the log fields and the audit actor: set it only if a gateway in front of the service verifies the tokens.
+type JWTConfig struct {
+	Header     string            `yaml:"header" mapstructure:"header" json:"header,omitempty" gorm:"column:header" bson:"header,omitempty" dynamodbav:"header,omitempty" firestore:"header,omitempty"`
+	Cookie     string            `yaml:"cookie" mapstructure:"cookie" json:"cookie,omitempty" gorm:"column:cookie" bson:"cookie,omitempty" dynamodbav:"cookie,omitempty" firestore:"cookie,omitempty"`
+	Claims     map[string]string `yaml:"claims" mapstructure:"claims" json:"claims,omitempty" gorm:"column:claims" bson:"claims,omitempty" dynamodbav:"claims,omitempty" firestore:"claims,omitempty"`
+	Context    string            `yaml:"context" mapstructure:"context" json:"context,omitempty" gorm:"column:context" bson:"context,omitempty" dynamodbav:"context,omitempty" firestore:"context,omitempty"`
+	Algorithms []string          `yaml:"algorithms" mapstructure:"algorithms" json:"algorithms,omitempty" gorm:"column:algorithms" bson:"algorithms,omitempty" dynamodbav:"algorithms,omitempty" firestore:"algorithms,omitempty"`
+	Secret     string            `yaml:"secret" mapstructure:"secret" json:"secret,omitempty" gorm:"column:secret" bson:"secret,omitempty" dynamodbav:"secret,omitempty" firestore:"secret,omitempty"`
+	KeyFile    string            `yaml:"key_file" mapstructure:"key_file" json:"keyFile,omitempty" gorm:"column:keyfile" bson:"keyFile,omitempty" dynamodbav:"keyFile,omitempty" firestore:"keyFile,omitempty"`
+	JWKSFile   string            `yaml:"jwks_file" mapstructure:"jwks_file" json:"jwksFile,omitempty" gorm:"column:jwksfile" bson:"jwksFile,omitempty" dynamodbav:"jwksFile,omitempty" firestore:"jwksFile,omitempty"`
+	Unverified bool              `yaml:"unverified" mapstructure:"unverified" json:"unverified,omitempty" gorm:"column:unverified" bson:"unverified,omitempty" dynamodbav:"unverified,omitempty" firestore:"unverified,omitempty"`
+	verify     bool
+	keys       []jwtKey
+}
+
+type jwtKey struct {
+	kid string
+	key interface{}
+}
+
+// CompileJWTConfig returns a copy of c with its keys loaded. It returns an error if c has no key and Unverified is not set.
+func CompileJWTConfig(c *JWTConfig) (*JWTConfig, error) {
+	jc := *c
+	if len(jc.Header) == 0 && len(jc.Cookie) == 0 {
+		jc.Header = "Authorization"
+	}
+	jc.keys = nil
+	if len(jc.Secret) > 0 {
+		jc.keys = append(jc.keys, jwtKey{key: []byte(jc.Secret)})
+	}
+	if len(jc.KeyFile) > 0 {
+		data, err := os.ReadFile(jc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read jwt key file %q: %w", jc.KeyFile, err)
+		}
+		key, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwt key file %q: %w", jc.KeyFile, err)
+		}
+		jc.keys = append(jc.keys, jwtKey{key: key})
+	}
+	if len(jc.JWKSFile) > 0 {
+		data, err := os.ReadFile(jc.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read jwks file %q: %w", jc.JWKSFile, err)
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwks file %q: %w", jc.JWKSFile, err)
+		}
+		jc.keys = append(jc.keys, keys...)
+	}
+	jc.verify = len(jc.keys) > 0
+	if !jc.verify && !jc.Unverified {
+		return nil, errors.New("jwt requires a secret, a key file or a jwks file, or unverified to accept unverified tokens")
+	}
+	for _, alg := range jc.Algorithms {
+		if _, _, err := jwtAlgorithm(alg); err != nil {
+			return nil, err
+		}
+	}
+	return &jc, nil
+}
+
+// ParsePublicKey parses a PEM encoded RSA or ECDSA public key, in PKIX or PKCS #1 form, or certificate.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+func parseJWKS(data []byte) ([]jwtKey, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]jwtKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+				return nil, fmt.Errorf("invalid RSA key %q", k.Kid)
+			}
+			pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			keys = append(keys, jwtKey{kid: k.Kid, key: pub})
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				return nil, fmt.Errorf("unsupported curve %q of key %q", k.Crv, k.Kid)
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid EC key %q", k.Kid)
+			}
+			pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			keys = append(keys, jwtKey{kid: k.Kid, key: pub})
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("invalid oct key %q", k.Kid)
+			}
+			keys = append(keys, jwtKey{kid: k.Kid, key: secret})
+		}
+	}
+	return keys, nil
+}
+
+// GetJWT returns the token of r, from the header or the cookie of c.
+func GetJWT(r *http.Request, c *JWTConfig) string {
+	if len(c.Header) > 0 {
+		if token := r.Header.Get(c.Header); len(token) > 0 {
+			if len(token) > 7 && strings.EqualFold(token[:7], "Bearer ") {
+				return strings.TrimSpace(token[7:])
+			}
+			return token
+		}
+	}
+	if len(c.Cookie) > 0 {
+		if cookie, err := r.Cookie(c.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// ParseJWT returns the claims of token, after verifying it with the keys of c. Without keys, it returns them unverified
+// only if c.Unverified is set. The exp and nbf claims are checked in both cases. c must be compiled.
+func ParseJWT(token string, c *JWTConfig) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if !c.verify {
+		if !c.Unverified {
+			return nil, errors.New("jwt cannot be verified without keys")
+		}
+		return checkJWTTime(claims)
+	}
+	if len(c.Algorithms) > 0 && !Include(c.Algorithms, header.Alg) {
+		return nil, fmt.Errorf("jwt algorithm %q is not allowed", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed jwt signature")
+	}
+	if err = verifyJWT(c.keys, header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	return checkJWTTime(claims)
+}
+
+// checkJWTTime returns claims, or an error if they are expired or not valid yet.
+func checkJWTTime(claims map[string]interface{}) (map[string]interface{}, error) {
+	now := time.Now().Unix()
+	if exp, ok := claims["exp"].(float64); ok && now >= int64(exp) {
+		return nil, errors.New("jwt has expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < int64(nbf) {
+		return nil, errors.New("jwt is not valid yet")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return errors.New("malformed jwt")
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return errors.New("malformed jwt")
+	}
+	return nil
+}
+
+// jwtAlgorithm returns the key family ("HS", "RS", "PS" or "ES") and hash of alg.
+func jwtAlgorithm(alg string) (string, crypto.Hash, error) {
+	if len(alg) == 5 {
+		var h crypto.Hash
+		switch alg[2:] {
+		case "256":
+			h = crypto.SHA256
+		case "384":
+			h = crypto.SHA384
+		case "512":
+			h = crypto.SHA512
+		}
+		switch family := alg[:2]; family {
+		case "HS", "RS", "PS", "ES":
+			if h != 0 {
+				return family, h, nil
+			}
+		}
+	}
+	return "", 0, fmt.Errorf("unsupported jwt algorithm %q", alg)
+}
+
+func verifyJWT(keys []jwtKey, alg string, kid string, input string, signature []byte) error {
+	family, h, err := jwtAlgorithm(alg)
+	if err != nil {
+		return err
+	}
+	hasher := h.New()
+	hasher.Write([]byte(input))
+	digest := hasher.Sum(nil)
+	for _, k := range keys {
+		if len(kid) > 0 && len(k.kid) > 0 && kid != k.kid {
+			continue
+		}
+		switch key := k.key.(type) {
+		case []byte:
+			if family == "HS" {
+				mac := hmac.New(h.New, key)
+				mac.Write([]byte(input))
+				if hmac.Equal(mac.Sum(nil), signature) {
+					return nil
+				}
+			}
+		case *rsa.PublicKey:
+			if family == "RS" && rsa.VerifyPKCS1v15(key, h, digest, signature) == nil {
+				return nil
+			}
+			if family == "PS" && rsa.VerifyPSS(key, h, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if family == "ES" && len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(key, digest, r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("invalid jwt signature")
+}
+
+// BuildClaimsContext returns the context of r with the claims of its JWT, the string values of the keys in masks masked by mask.
+// It returns false if r has no valid token.
+func BuildClaimsContext(r *http.Request, c *JWTConfig, masks []string, mask func(fieldName, s string) string) (context.Context, bool) {
+	ctx := r.Context()
+	token := GetJWT(r, c)
+	if len(token) == 0 {
+		return ctx, false
+	}
+	claims, err := ParseJWT(token, c)
+	if err != nil {
+		return ctx, false
+	}
+	if len(c.Context) > 0 {
+		ctx = context.WithValue(ctx, c.Context, claims)
+	}
+	for k, e := range c.Claims {
+		v := ValueOf(claims, e)
+		if v == nil {
+			continue
+		}
+		if s, ok := v.(string); ok {
+			if len(s) == 0 {
+				continue
+			}
+			if mask != nil && Include(masks, k) {
+				v = mask(k, s)
+			}
+		}
+		ctx = context.WithValue(ctx, k, v)
+	}
+	return ctx, true
+}
+
+// BuildClaimFields adds the claims of c.JWT found in the context of r into fields.
+func BuildClaimFields(c LogConfig, r *http.Request, fields map[string]interface{}) {
+	if c.JWT == nil {
+		return
+	}
+	ctx := r.Context()
+	for k := range c.JWT.Claims {
+		if v := ctx.Value(k); v != nil {
+			fields[k] = v
+		}
+	}
+}
+
+// BuildContextWithClaims maps the claims of the JWT of each request into its context, as configured by Config.JWT.
+// The logger adds them to the log fields if it runs inside this middleware.
+func (l *HttpLogger) BuildContextWithClaims(next http.Handler) http.Handler {
+	// compile Config before serving concurrent requests
+	l.getFieldConfig()
+	next = routeHandler(next)
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		_, fc := l.load()
+		if fc.JWT != nil {
+			if ctx, ok := BuildClaimsContext(r, fc.JWT, fc.Masks, l.Mask); ok {
+				r = r.WithContext(ctx)
+			}
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseJWTRequiresKeysOrUnverified(t *testing.T) {
+	if _, err := CompileJWTConfig(&JWTConfig{}); err == nil {
+		t.Fatal("no error for a config without keys")
+	}
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin"}`))
+	token := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + claims + "."
+
+	c, err := CompileJWTConfig(&JWTConfig{Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseJWT(token, c); err == nil {
+		t.Fatal("unsigned token accepted with a secret")
+	}
+	c, err = CompileJWTConfig(&JWTConfig{Unverified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := ParseJWT(token, c); err != nil || m["sub"] != "admin" {
+		t.Fatalf("unverified token rejected: %v %v", m, err)
+	}
+}
+
+func TestParseJWTChecksTimeWhenUnverified(t *testing.T) {
+	c, err := CompileJWTConfig(&JWTConfig{Unverified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	now := time.Now().Unix()
+	for name, payload := range map[string]string{
+		"expired":       fmt.Sprintf(`{"sub":"admin","exp":%d}`, now-60),
+		"not valid yet": fmt.Sprintf(`{"sub":"admin","nbf":%d}`, now+60),
+	} {
+		token := header + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + "."
+		if m, err := ParseJWT(token, c); err == nil {
+			t.Errorf("%s token accepted: %v", name, m)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import "testing"
+
+func TestValidateLevelConfig(t *testing.T) {
+	valid := &LevelConfig{Default: LevelDebug, Status: map[string]string{"404": LevelInfo, "5xx": LevelError}, SlowLevel: LevelWarn}
+	if err := ValidateLevelConfig(valid); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*LevelConfig{
+		{Default: "verbose"},
+		{Status: map[string]string{"5xx": "fatal"}},
+		{SlowLevel: "Warn"},
+		{Panic: "critical"},
+	} {
+		if err := ValidateLevelConfig(c); err == nil {
+			t.Errorf("no error for %+v", *c)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blockedQueue returns a queue of one worker and one slot, with the worker blocked until release is closed.
+func blockedQueue(overflow string) (*LogQueue, chan struct{}) {
+	q := NewLogQueue(AsyncConfig{QueueSize: 1, Workers: 1, Overflow: overflow})
+	release, started := make(chan struct{}), make(chan struct{})
+	q.Enqueue(func() {
+		close(started)
+		<-release
+	})
+	<-started
+	return q, release
+}
+
+func TestLogQueueDropNewest(t *testing.T) {
+	q, release := blockedQueue(OverflowDropNewest)
+	if !q.Enqueue(func() {}) {
+		t.Fatal("job dropped while the queue has room")
+	}
+	if q.Enqueue(func() {}) || q.Enqueue(func() {}) {
+		t.Fatal("job accepted while the queue is full")
+	}
+	if q.Dropped() != 2 || q.Pending() != 2 {
+		t.Fatalf("dropped %d, pending %d, want 2 and 2", q.Dropped(), q.Pending())
+	}
+	close(release)
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if q.Pending() != 0 {
+		t.Fatalf("pending %d after Shutdown", q.Pending())
+	}
+}
+
+func TestLogQueueDropOldest(t *testing.T) {
+	q, release := blockedQueue(OverflowDropOldest)
+	var last int
+	for i := 1; i <= 3; i++ {
+		i := i
+		if !q.Enqueue(func() { last = i }) {
+			t.Fatalf("job %d dropped", i)
+		}
+	}
+	if q.Dropped() != 2 {
+		t.Fatalf("dropped %d, want 2", q.Dropped())
+	}
+	close(release)
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if last != 3 {
+		t.Fatalf("last job %d, want the newest 3", last)
+	}
+}
+
+func TestLogQueueShutdownReleasesBlockedEnqueue(t *testing.T) {
+	q, release := blockedQueue(OverflowBlock)
+	defer close(release)
+	q.Enqueue(func() {})
+	enqueued := make(chan bool)
+	go func() {
+		enqueued <- q.Enqueue(func() {})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Shutdown returned %v, want the deadline of the blocked worker", err)
+	}
+	select {
+	case ok := <-enqueued:
+		if ok {
+			t.Fatal("blocked job accepted after Shutdown")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Enqueue still blocked after Shutdown")
+	}
+	if q.Enqueue(func() {}) {
+		t.Fatal("job accepted after Shutdown")
+	}
+	if q.Dropped() != 2 {
+		t.Fatalf("dropped %d, want 2", q.Dropped())
+	}
+}
+
+func TestNewFieldConfigRejectsUnknownOverflow(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic for an unknown overflow policy")
+		}
+	}()
+	NewFieldConfig(LogConfig{Async: &AsyncConfig{Overflow: "drop"}})
+}
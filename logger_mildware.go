@@ -2,88 +2,420 @@ package middleware
 
 import (
 	"context"
+	"crypto/sha256"
+	"hash"
 	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
-func InitializeFieldConfig(c LogConfig) {
+// CompileFieldConfig compiles c into the FieldConfig used by a logger instance and its context builder.
+// It returns the first error reported by the Compile and Validate functions of the parts of c.
+func CompileFieldConfig(c LogConfig) (FieldConfig, error) {
+	var fc FieldConfig
 	if len(c.Duration) > 0 {
-		fieldConfig.Duration = c.Duration
+		fc.Duration = c.Duration
 	} else {
-		fieldConfig.Duration = "duration"
+		fc.Duration = "duration"
+	}
+	fc.Log = c.Log
+	fc.Ip = c.Ip
+	ipConfig, err := CompileIpConfig(c.IpConfig)
+	if err != nil {
+		return FieldConfig{}, err
 	}
-	fieldConfig.Log = c.Log
-	fieldConfig.Ip = c.Ip
+	fc.IpConfig = ipConfig
 	if c.Map != nil && len(c.Map) > 0 {
-		fieldConfig.Map = c.Map
+		fc.Map = c.Map
 	}
 	if c.Constants != nil && len(c.Constants) > 0 {
-		fieldConfig.Constants = c.Constants
+		fc.Constants = c.Constants
 	}
 	if c.Headers != nil && len(c.Headers) > 0 {
-		fieldConfig.Headers = c.Headers
+		fc.Headers = c.Headers
 	}
 	if len(c.Fields) > 0 {
 		fields := strings.Split(c.Fields, ",")
-		fieldConfig.Fields = fields
+		fc.Fields = fields
 	}
 	if len(c.Masks) > 0 {
 		fields := strings.Split(c.Masks, ",")
-		fieldConfig.Masks = fields
+		fc.Masks = fields
 	}
 	if len(c.Skips) > 0 {
 		fields := strings.Split(c.Skips, ",")
-		fieldConfig.Skips = fields
+		fc.Skips = fields
+	}
+	if len(c.BodyMethods) > 0 {
+		fields := strings.Split(c.BodyMethods, ",")
+		fc.BodyMethods = fields
+	}
+	if len(c.BodyTypes) > 0 {
+		fields := strings.Split(c.BodyTypes, ",")
+		fc.BodyTypes = fields
+	}
+	if len(c.SkipRules) > 0 {
+		rules, err := CompilePathPatterns(c.SkipRules)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.SkipRules = rules
+	}
+	if c.Sampling != nil {
+		sampling, err := CompileSamplingConfig(c.Sampling)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Sampling = sampling
+	}
+	if c.Slow != nil {
+		slow, err := CompileSlowConfig(c.Slow)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Slow = slow
+	}
+	if c.Deferred != nil {
+		deferred, err := CompileDeferredConfig(c.Deferred)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Deferred = deferred
+	}
+	if c.JWT != nil {
+		jwt, err := CompileJWTConfig(c.JWT)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.JWT = jwt
+	}
+	if c.Proxy != nil {
+		fc.Proxy = CompileProxyConfig(c.Proxy)
+	}
+	if len(c.Captures) > 0 {
+		captures, err := CompileCaptureRules(c.Captures)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Captures = captures
+	}
+	if c.Debug != nil {
+		debug, err := CompileDebugConfig(c.Debug)
+		if err != nil {
+			return FieldConfig{}, err
+		}
+		fc.Debug = debug
+	}
+	if c.Async != nil {
+		if err := ValidateAsyncConfig(*c.Async); err != nil {
+			return FieldConfig{}, err
+		}
+	}
+	if err := ValidateLevelConfig(c.Levels); err != nil {
+		return FieldConfig{}, err
+	}
+	if err := ValidateQueryRules(c.QueryRules, c.QueryKey); err != nil {
+		return FieldConfig{}, err
+	}
+	fc.routeRules = hasRouteRules(&fc)
+	return fc, nil
+}
+
+// NewFieldConfig compiles c like CompileFieldConfig, and panics if c is invalid.
+func NewFieldConfig(c LogConfig) FieldConfig {
+	fc, err := CompileFieldConfig(c)
+	if err != nil {
+		panic(err)
+	}
+	return fc
+}
+
+// InitializeFieldConfig sets the package-level config used by BuildContext and BuildContextWithMask.
+func InitializeFieldConfig(c LogConfig) {
+	fieldConfig = NewFieldConfig(c)
+}
+
+type HttpLogger struct {
+	Config  LogConfig
+	LogInfo func(ctx context.Context, msg string, fields map[string]interface{})
+	f       Formatter
+	Mask    func(fieldName, s string) string
+	// Levels are the log functions of the levels of LogConfig.Levels, LogInfo is used for the missing ones.
+	Levels LevelLogger
+	// Route extracts the route template with a router such as chi or gorilla/mux. GetRoute is used if it is nil or returns "".
+	Route func(r *http.Request) string
+	// Holder, if set, replaces Config: the loggers reload it on each request.
+	Holder      *ConfigHolder
+	fieldConfig *FieldConfig
+	debugErrors int64
+	routeErrors int64
+	queue       *LogQueue
+}
+
+func NewHttpLogger(c LogConfig, logInfo func(ctx context.Context, msg string, fields map[string]interface{}), f Formatter, mask func(fieldName, s string) string) *HttpLogger {
+	fc := NewFieldConfig(c)
+	// the log fields resolve the client IP with the compiled trusted proxies
+	c.Proxy = fc.Proxy
+	l := &HttpLogger{Config: c, LogInfo: logInfo, f: f, Mask: mask, fieldConfig: &fc}
+	if c.Async != nil {
+		l.queue = NewLogQueue(*c.Async)
+	}
+	return l
+}
+
+func NewHttpLoggerWithLevels(c LogConfig, logs LevelLogger, f Formatter, mask func(fieldName, s string) string) *HttpLogger {
+	l := NewHttpLogger(c, logs.Info, f, mask)
+	l.Levels = logs
+	return l
+}
+
+// getFieldConfig compiles Config on first use, for loggers not created by NewHttpLogger.
+func (l *HttpLogger) getFieldConfig() *FieldConfig {
+	if l.fieldConfig == nil {
+		fc := NewFieldConfig(l.Config)
+		l.fieldConfig = &fc
+	}
+	return l.fieldConfig
+}
+
+// load returns the config of a request: the current config of Holder if it is set.
+func (l *HttpLogger) load() (LogConfig, *FieldConfig) {
+	if l.Holder != nil {
+		return l.Holder.Load()
+	}
+	return l.Config, l.fieldConfig
+}
+
+// debug applies the debug token of r, counting the invalid ones.
+func (l *HttpLogger) debug(r *http.Request, c LogConfig, fc *FieldConfig) (*http.Request, LogConfig, *FieldConfig) {
+	dr, dc, dfc, err := DebugRequest(r, c, fc)
+	if err != nil {
+		atomic.AddInt64(&l.debugErrors, 1)
+	}
+	return dr, dc, dfc
+}
+
+// DebugErrors returns the number of invalid or expired debug tokens received.
+func (l *HttpLogger) DebugErrors() int64 {
+	return atomic.LoadInt64(&l.debugErrors)
+}
+
+// RouteErrors returns the number of requests whose skip, sampling or capture rules could not match the route template,
+// because it was not known yet: the logger runs before http.ServeMux routes the request and Route is not set.
+func (l *HttpLogger) RouteErrors() int64 {
+	return atomic.LoadInt64(&l.routeErrors)
+}
+
+func (l *HttpLogger) logFunc(level string) func(ctx context.Context, msg string, fields map[string]interface{}) {
+	if fn := l.Levels.Func(level); fn != nil {
+		return fn
 	}
+	return l.LogInfo
 }
+
+func (l *HttpLogger) route(r *http.Request) string {
+	if l.Route != nil {
+		if route := l.Route(r); len(route) > 0 {
+			return route
+		}
+	}
+	return GetRoute(r)
+}
+
+func (l *HttpLogger) getQueue() *LogQueue {
+	if l.queue == nil && l.Config.Async != nil {
+		l.queue = NewLogQueue(*l.Config.Async)
+	}
+	return l.queue
+}
+
+// Flush waits until the queued records are written, if Config.Async is set.
+func (l *HttpLogger) Flush(ctx context.Context) error {
+	if l.queue == nil {
+		return nil
+	}
+	return l.queue.Flush(ctx)
+}
+
+// Shutdown drains the queued records and stops the workers, if Config.Async is set.
+func (l *HttpLogger) Shutdown(ctx context.Context) error {
+	if l.queue == nil {
+		return nil
+	}
+	return l.queue.Shutdown(ctx)
+}
+
+// Dropped returns the number of records dropped by the queue, if Config.Async is set.
+func (l *HttpLogger) Dropped() int64 {
+	if l.queue == nil {
+		return 0
+	}
+	return l.queue.Dropped()
+}
+
+// Pending returns the number of records queued or being written, if Config.Async is set.
+func (l *HttpLogger) Pending() int64 {
+	if l.queue == nil {
+		return 0
+	}
+	return l.queue.Pending()
+}
+func (l *HttpLogger) BuildContextWithMask(next http.Handler) http.Handler {
+	// compile Config before serving concurrent requests
+	l.getFieldConfig()
+	return buildContextWithMask(next, func() *FieldConfig {
+		_, fc := l.load()
+		return fc
+	}, l.Mask)
+}
+func (l *HttpLogger) BuildContext(next http.Handler) http.Handler {
+	return l.BuildContextWithMask(next)
+}
+
+// Logger keeps the package-level config of BuildContext in sync with c, but the returned middleware only uses its own copy.
+// Use NewHttpLogger to run several independently configured loggers with their own context builders.
 func Logger(c LogConfig, log func(ctx context.Context, msg string, fields map[string]interface{}), f Formatter) func(h http.Handler) http.Handler {
 	InitializeFieldConfig(c)
-	return func(h http.Handler) http.Handler {
-		fn := func(w http.ResponseWriter, r *http.Request) {
-			if !fieldConfig.Log || InSkipList(r, fieldConfig.Skips) {
-				h.ServeHTTP(w, r)
+	return NewHttpLogger(c, log, f, nil).Logger
+}
+
+// Logger logs the requests served by h. The skip, sampling and capture rules matching the route template need the route
+// before h runs: the logger must run inside the http.ServeMux, or Route must be set. Otherwise RouteErrors counts the requests.
+func (l *HttpLogger) Logger(h http.Handler) http.Handler {
+	// compile Config before serving concurrent requests
+	l.getFieldConfig()
+	h = routeHandler(h)
+	q := l.getQueue()
+	f := l.f
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		c, fc := l.load()
+		if l.Route != nil {
+			if route := l.Route(r); len(route) > 0 {
+				r = WithRoute(r, route)
+			}
+		} else if fc.routeRules && len(GetRoute(r)) == 0 {
+			// the config may be reloaded, so the rules are checked on each request
+			atomic.AddInt64(&l.routeErrors, 1)
+		}
+		c, fc = CaptureBodies(r, c, fc)
+		r, c, fc = l.debug(r, c, fc)
+		if !fc.Log || InSkipList(r, fc.Skips) || InSkipRules(r, fc.SkipRules) {
+			h.ServeHTTP(w, r)
+		} else {
+			r = withRouteRequest(r)
+			dw := NewResponseWriter(w, DeferredLimit(c.ResponseLimit, fc.Deferred))
+			dw.Hash = newHash(c)
+			ww := NewWrapResponseWriter(dw, r.ProtoMajor)
+			startTime := time.Now()
+			fields := BuildLogFieldsWithMask(c, r, l.Mask)
+			rate, sampled, byTrace := 1.0, true, false
+			if fc.Sampling != nil {
+				rate, sampled, byTrace = HeadSample(r, fc.Sampling)
+				BuildSampleFields(fc.Sampling, rate, byTrace, fields)
+			}
+			includeRequest := !c.Separate || !sampled || fc.Deferred != nil
+			readStart := time.Now()
+			var slowBody *SlowBody
+			if !CaptureMethod(r.Method, fc.BodyMethods) {
+				includeRequest = true
+				slowBody = CaptureSlowRequest(r, fc.Slow, fc.BodyTypes)
+			} else if CaptureRequestType(r.Header.Get("Content-Type"), fc.BodyTypes) {
+				BuildRequestBodyWithLimit(r, c.Request, DeferredLimit(c.RequestLimit, fc.Deferred), fields)
+				if len(c.Request) == 0 {
+					slowBody = CaptureSlowRequest(r, fc.Slow, fc.BodyTypes)
+				}
 			} else {
-				dw := NewResponseWriter(w)
-				ww := NewWrapResponseWriter(dw, r.ProtoMajor)
-				startTime := time.Now()
-				fields := BuildLogFields(c, r)
-				includeRequest := !c.Separate
-				if r.Method == "GET" || r.Method == "DELETE" || strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
-					includeRequest = true
+				includeRequest = true
+				BuildBinaryRequestBody(r, c.Request, newHash(c), fields)
+			}
+			read := time.Since(readStart)
+			if len(c.ReadTime) > 0 {
+				TimeRequestBody(r)
+			}
+			if !includeRequest {
+				level := RequestLevel(c.Levels)
+				log, lr := l.logFunc(level), BuildLogRequest(r, c, level, l.Mask)
+				Dispatch(q, func() {
+					f.LogRequest(log, lr, fields)
+				})
+			}
+			completed := false
+			defer func() {
+				elapsed := time.Since(startTime)
+				slow := IsSlow(r, fc.Slow, elapsed)
+				if fc.Sampling != nil {
+					if slow || TailSample(fc.Sampling, ww.Status(), elapsed) {
+						rate, byTrace = 1, false
+					} else if !sampled {
+						return
+					}
+				}
+				resFields := fields
+				if includeRequest {
+					UpdateRequestSize(r, c.Request, fields)
 				} else {
-					BuildRequestBody(r, c.Request, fields)
+					resFields = BuildLogFieldsWithMask(c, r, l.Mask)
+				}
+				if fc.Sampling != nil {
+					BuildSampleFields(fc.Sampling, rate, byTrace, resFields)
 				}
-				if !includeRequest {
-					go f.LogRequest(log, r, fields)
+				BuildTimingFields(c, r, startTime, read, dw.Timing, resFields)
+				BuildRouteField(c, l.route(r), resFields)
+				BuildServerTimingFields(c, r, resFields)
+				rc, response := c, dw.Body.String()
+				if slow {
+					rc, response = EscalateSlow(c, fc.Slow, r, slowBody, response, resFields)
 				}
-				defer func() {
-					if includeRequest {
-						go f.LogResponse(log, r, ww, c, startTime, dw.Body.String(), fields, includeRequest)
+				if fc.Deferred != nil {
+					if KeepBodies(fc.Deferred, ww.Status(), !completed) {
+						rc.ResponseLimit = DeferredLimit(rc.ResponseLimit, fc.Deferred)
 					} else {
-						resFields := BuildLogFields(c, r)
-						go f.LogResponse(log, r, ww, c, startTime, dw.Body.String(), resFields, includeRequest)
+						rc = DiscardBodies(rc, resFields)
 					}
-				}()
-				h.ServeHTTP(ww, r)
-			}
+				}
+				contentType := ResponseContentType(ww.Header(), dw.Body.Bytes())
+				rc = BuildBinaryResponseBody(rc, fc.BodyTypes, contentType, ww.BytesWritten(), dw.Hash, resFields)
+				level := ResponseLevel(c.Levels, ww.Status(), elapsed, !completed)
+				if slow {
+					level = MaxLevel(level, fc.Slow.Level)
+				}
+				log, lr := l.logFunc(level), BuildLogRequest(r, c, level, l.Mask)
+				// shift startTime, so that the duration logged by the formatter excludes the time spent in the queue
+				Dispatch(q, func() {
+					f.LogResponse(log, lr, ww, rc, time.Now().Add(-elapsed), response, resFields, includeRequest || slow)
+				})
+			}()
+			h.ServeHTTP(ww, r)
+			completed = true
 		}
-		return http.HandlerFunc(fn)
 	}
+	return http.HandlerFunc(fn)
 }
+
+// InSkipList reports whether the path of r, without query string, ends with one of skips.
 func InSkipList(r *http.Request, skips []string) bool {
 	if skips == nil || len(skips) == 0 {
 		return false
 	}
 	for _, s := range skips {
-		if strings.HasSuffix(s, r.RequestURI) {
+		s = strings.TrimSpace(s)
+		if len(s) > 0 && strings.HasSuffix(r.URL.Path, s) {
 			return true
 		}
 	}
 	return false
 }
+func InSkipRules(r *http.Request, rules []PathPattern) bool {
+	return MatchPathPatterns(r, rules) != nil
+}
 func BuildLogFields(c LogConfig, r *http.Request) map[string]interface{} {
+	return BuildLogFieldsWithMask(c, r, nil)
+}
+
+// BuildLogFieldsWithMask builds the request fields, applying c.QueryRules with mask to the uri and query fields.
+func BuildLogFieldsWithMask(c LogConfig, r *http.Request, mask func(fieldName, s string) string) map[string]interface{} {
 	fields := make(map[string]interface{}, 0)
 	if !c.Build {
 		return fields
@@ -93,7 +425,13 @@ func BuildLogFields(c LogConfig, r *http.Request) map[string]interface{} {
 		scheme = "https"
 	}
 	if len(c.Uri) > 0 {
-		fields[c.Uri] = r.RequestURI
+		fields[c.Uri] = MaskRequestURI(r.RequestURI, c.QueryRules, c.QueryKey, mask)
+	}
+	BuildRouteField(c, GetRoute(r), fields)
+	if len(c.Query) > 0 {
+		if query := BuildQuery(r.URL.RawQuery, c.QueryRules, c.QueryKey, mask); len(query) > 0 {
+			fields[c.Query] = query
+		}
 	}
 
 	if len(c.ReqId) > 0 {
@@ -101,6 +439,9 @@ func BuildLogFields(c LogConfig, r *http.Request) map[string]interface{} {
 			fields[c.ReqId] = reqID
 		}
 	}
+	BuildTraceFields(c, r, fields)
+	BuildClaimFields(c, r, fields)
+	BuildDebugField(c, r, fields)
 	if len(c.Scheme) > 0 {
 		fields[c.Scheme] = scheme
 	}
@@ -111,17 +452,52 @@ func BuildLogFields(c LogConfig, r *http.Request) map[string]interface{} {
 		fields[c.UserAgent] = r.UserAgent()
 	}
 	if len(c.RemoteAddr) > 0 {
-		fields[c.RemoteAddr] = r.RemoteAddr
+		if remoteAddr, ok := c.AnonymizeAddr(r.RemoteAddr); ok {
+			fields[c.RemoteAddr] = remoteAddr
+		}
 	}
 	if len(c.Method) > 0 {
 		fields[c.Method] = r.Method
 	}
 	if len(c.RemoteIp) > 0 {
-		remoteIP := getRemoteIp(r)
-		fields[c.RemoteIp] = remoteIP
+		if remoteIP, ok := c.Anonymize(ClientIP(r, c.Proxy)); ok {
+			fields[c.RemoteIp] = remoteIP
+		}
+	}
+	if c.LogHeaders != nil {
+		BuildHeaders(r.Header, c.LogHeaders.Request, c.LogHeaders, fields)
 	}
 	return fields
 }
+
+// BuildLogRequest returns the request passed to the Formatter: a shallow copy of r with level in its context
+// and c.QueryRules applied to its RequestURI, or r itself if neither is needed.
+func BuildLogRequest(r *http.Request, c LogConfig, level string, mask func(fieldName, s string) string) *http.Request {
+	// the level is also set without c.Levels if it is not the default, such as the level of an escalated slow request
+	withLevel := c.Levels != nil || level != LevelInfo
+	if !withLevel && len(c.QueryRules) == 0 {
+		return r
+	}
+	ctx := r.Context()
+	if withLevel {
+		ctx = context.WithValue(ctx, LevelKey, level)
+	}
+	lr := r.WithContext(ctx)
+	lr.RequestURI = MaskRequestURI(r.RequestURI, c.QueryRules, c.QueryKey, mask)
+	return lr
+}
+func newHash(c LogConfig) hash.Hash {
+	if c.BodyHash {
+		return sha256.New()
+	}
+	return nil
+}
+func durationField(c LogConfig) string {
+	if len(c.Duration) > 0 {
+		return c.Duration
+	}
+	return "duration"
+}
 func getRemoteIp(r *http.Request) string {
 	remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
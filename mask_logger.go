@@ -41,7 +41,7 @@ func (l *MaskLogger) LogResponse(log func(context.Context, string, map[string]in
 	msg := r.Method + " " + r.RequestURI
 	log(r.Context(), msg, fields)
 	if l.send != nil {
-		go Send(r.Context(), l.send, msg, fields, l.KeyMap)
+		Send(r.Context(), l.send, msg, fields, l.KeyMap)
 	}
 }
 func (l *MaskLogger) LogRequest(log func(context.Context, string, map[string]interface{}), r *http.Request, fields map[string]interface{}) {
@@ -49,47 +49,58 @@ func (l *MaskLogger) LogRequest(log func(context.Context, string, map[string]int
 	msg := "Request " + r.Method + " " + r.RequestURI
 	log(r.Context(), msg, fields)
 	if l.send != nil {
-		go Send(r.Context(), l.send, msg, fields, l.KeyMap)
+		Send(r.Context(), l.send, msg, fields, l.KeyMap)
 	}
 }
 
+// MaskResponse logs the response body masked by mask. A truncated body is logged without its content, as it cannot be masked.
 func MaskResponse(ww WrapResponseWriter, c LogConfig, t1 time.Time, response string, fields map[string]interface{}, mask func(map[string]interface{}), isJsonFormat bool) {
 	if len(c.Response) > 0 {
-		fields[c.Response] = response
-		responseBody := response
-		responseMap := map[string]interface{}{}
-		json.Unmarshal([]byte(responseBody), &responseMap)
-		if len(responseMap) > 0 {
-			mask(responseMap)
-			if isJsonFormat {
-				fields[c.Response] = responseMap
-			} else {
-				responseString, err := json.Marshal(responseMap)
-				if err != nil {
-					fmt.Printf("Error: %s", err.Error())
+		if size := ww.BytesWritten(); c.ResponseLimit > 0 && len(response) < size {
+			// a truncated JSON body cannot be masked, so only its size is logged
+			fields[c.Response] = TruncatedBody{Truncated: true, Size: int64(size)}
+		} else {
+			fields[c.Response] = response
+			responseBody := response
+			responseMap := map[string]interface{}{}
+			json.Unmarshal([]byte(responseBody), &responseMap)
+			if len(responseMap) > 0 {
+				mask(responseMap)
+				if isJsonFormat {
+					fields[c.Response] = responseMap
 				} else {
-					fields[c.Response] = string(responseString)
+					responseString, err := json.Marshal(responseMap)
+					if err != nil {
+						fmt.Printf("Error: %s", err.Error())
+					} else {
+						fields[c.Response] = string(responseString)
+					}
 				}
 			}
 		}
 	}
+	if c.LogHeaders != nil {
+		BuildHeaders(ww.Header(), c.LogHeaders.Response, c.LogHeaders, fields)
+	}
 	if len(c.ResponseStatus) > 0 {
 		fields[c.ResponseStatus] = ww.Status()
 	}
-	if len(fieldConfig.Duration) > 0 {
-		t2 := time.Now()
-		duration := t2.Sub(t1)
-		fields[fieldConfig.Duration] = duration.Milliseconds()
-	}
+	t2 := time.Now()
+	duration := t2.Sub(t1)
+	fields[durationField(c)] = FormatDuration(duration, c.DurationUnit)
 	if len(c.Size) > 0 {
 		fields[c.Size] = ww.BytesWritten()
 	}
 }
 
+// MaskRequest masks the request body of fields. A truncated body is replaced by its size, as it cannot be masked.
 func MaskRequest(request string, fields map[string]interface{}, mask func(map[string]interface{}), isJsonFormat bool) {
 	if len(request) > 0 {
 		req, ok := fields[request]
-		if ok {
+		if body, truncated := req.(TruncatedBody); truncated {
+			// a truncated JSON body cannot be masked, so only its size is logged
+			fields[request] = TruncatedBody{Truncated: body.Truncated, Size: body.Size}
+		} else if ok {
 			requestBody, ok2 := req.(string)
 			if ok2 {
 				requestMap := map[string]interface{}{}
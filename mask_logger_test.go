@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func maskPassword(m map[string]interface{}) {
+	if _, ok := m["password"]; ok {
+		m["password"] = "***"
+	}
+}
+
+func TestMaskRequestMasksFullBody(t *testing.T) {
+	fields := map[string]interface{}{"request": `{"user":"a","password":"secret"}`}
+	MaskRequest("request", fields, maskPassword, false)
+	body, _ := fields["request"].(string)
+	if strings.Contains(body, "secret") || !strings.Contains(body, `"password":"***"`) {
+		t.Fatalf("request body is not masked: %v", fields["request"])
+	}
+}
+
+func TestMaskRequestDropsTruncatedBody(t *testing.T) {
+	fields := map[string]interface{}{"request": TruncatedBody{Body: `{"password":"secr`, Truncated: true, Size: 100}}
+	MaskRequest("request", fields, maskPassword, false)
+	body, ok := fields["request"].(TruncatedBody)
+	if !ok {
+		t.Fatalf("request body is %T, want TruncatedBody", fields["request"])
+	}
+	if len(body.Body) > 0 || !body.Truncated || body.Size != 100 {
+		t.Fatalf("truncated request body is logged: %+v", body)
+	}
+}
+
+func TestMaskResponseDropsTruncatedBody(t *testing.T) {
+	full := `{"token":"abc","password":"secret"}`
+	ww := NewWrapResponseWriter(httptest.NewRecorder(), 1)
+	ww.Write([]byte(full))
+	c := LogConfig{Response: "response", ResponseLimit: 10}
+	fields := make(map[string]interface{})
+	MaskResponse(ww, c, time.Now(), full[:10], fields, maskPassword, false)
+	body, ok := fields["response"].(TruncatedBody)
+	if !ok {
+		t.Fatalf("response body is %T, want TruncatedBody", fields["response"])
+	}
+	if len(body.Body) > 0 || body.Size != int64(len(full)) {
+		t.Fatalf("truncated response body is logged: %+v", body)
+	}
+}
+
+func TestMaskResponseMasksFullBody(t *testing.T) {
+	full := `{"password":"secret"}`
+	ww := NewWrapResponseWriter(httptest.NewRecorder(), 1)
+	ww.Write([]byte(full))
+	fields := make(map[string]interface{})
+	MaskResponse(ww, LogConfig{Response: "response", ResponseLimit: 100}, time.Now(), full, fields, maskPassword, false)
+	if body, _ := fields["response"].(string); strings.Contains(body, "secret") {
+		t.Fatalf("response body is not masked: %v", fields["response"])
+	}
+}
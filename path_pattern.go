@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchGlob   = "glob"
+	MatchRegex  = "regex"
+)
+
+// PathPattern matches the path of a request, without query string, and optionally its method.
+// Match is one of "exact" (default), "prefix", "glob" (path.Match syntax, "*" does not cross "/") or "regex".
+type PathPattern struct {
+	Path    string   `yaml:"path" mapstructure:"path" json:"path,omitempty" gorm:"column:path" bson:"path,omitempty" dynamodbav:"path,omitempty" firestore:"path,omitempty"`
+	Match   string   `yaml:"match" mapstructure:"match" json:"match,omitempty" gorm:"column:match" bson:"match,omitempty" dynamodbav:"match,omitempty" firestore:"match,omitempty"`
+	Methods []string `yaml:"methods" mapstructure:"methods" json:"methods,omitempty" gorm:"column:methods" bson:"methods,omitempty" dynamodbav:"methods,omitempty" firestore:"methods,omitempty"`
+	// Route matches Path against the route template of the request, such as "/users/:id", instead of its path.
+	// http.ServeMux sets the template only when it routes the request, so the skip, sampling and capture rules,
+	// which the HttpLogger evaluates before, match only if it runs inside the ServeMux or HttpLogger.Route is set.
+	Route bool `yaml:"route" mapstructure:"route" json:"route,omitempty" gorm:"column:route" bson:"route,omitempty" dynamodbav:"route,omitempty" firestore:"route,omitempty"`
+	re    *regexp.Regexp
+}
+
+// Compile validates the pattern and compiles its regular expression.
+func (p *PathPattern) Compile() error {
+	switch strings.ToLower(p.Match) {
+	case "", MatchExact, MatchPrefix:
+		return nil
+	case MatchGlob:
+		if _, err := path.Match(p.Path, ""); err != nil {
+			return fmt.Errorf("invalid glob pattern %q: %w", p.Path, err)
+		}
+		return nil
+	case MatchRegex:
+		re, err := regexp.Compile(p.Path)
+		if err != nil {
+			return fmt.Errorf("invalid regex pattern %q: %w", p.Path, err)
+		}
+		p.re = re
+		return nil
+	default:
+		return fmt.Errorf("invalid match type %q of pattern %q", p.Match, p.Path)
+	}
+}
+
+// MatchRequest reports whether method and urlPath match the pattern. The pattern must be compiled.
+func (p *PathPattern) MatchRequest(method string, urlPath string) bool {
+	if !p.MatchMethod(method) {
+		return false
+	}
+	switch strings.ToLower(p.Match) {
+	case MatchPrefix:
+		return strings.HasPrefix(urlPath, p.Path)
+	case MatchGlob:
+		ok, _ := path.Match(p.Path, urlPath)
+		return ok
+	case MatchRegex:
+		return p.re != nil && p.re.MatchString(urlPath)
+	default:
+		return urlPath == p.Path
+	}
+}
+
+// MatchMethod reports whether method is one of Methods, or Methods is empty.
+func (p *PathPattern) MatchMethod(method string) bool {
+	if len(p.Methods) == 0 {
+		return true
+	}
+	for _, m := range p.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether r matches the pattern, by its route template if Route is set. The pattern must be compiled.
+func (p *PathPattern) Matches(r *http.Request) bool {
+	if !p.Route {
+		return p.MatchRequest(r.Method, r.URL.Path)
+	}
+	route := GetRoute(r)
+	return len(route) > 0 && p.MatchRequest(r.Method, route)
+}
+
+// CompilePathPatterns returns compiled copies of patterns.
+func CompilePathPatterns(patterns []PathPattern) ([]PathPattern, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	compiled := make([]PathPattern, len(patterns))
+	for i := range patterns {
+		compiled[i] = patterns[i]
+		if err := compiled[i].Compile(); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+// MatchPathPatterns returns the first of the compiled patterns that matches the request, or nil.
+func MatchPathPatterns(r *http.Request, patterns []PathPattern) *PathPattern {
+	for i := range patterns {
+		if patterns[i].Matches(r) {
+			return &patterns[i]
+		}
+	}
+	return nil
+}
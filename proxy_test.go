@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8"}
+	tests := []struct {
+		name    string
+		c       *ProxyConfig
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"no config", nil, "10.0.0.1:1234", map[string]string{HeaderXForwardedFor: "1.2.3.4"}, "10.0.0.1"},
+		{"untrusted peer", &ProxyConfig{TrustedProxies: trusted}, "8.8.8.8:1234", map[string]string{HeaderXForwardedFor: "1.2.3.4"}, "8.8.8.8"},
+		{"rightmost untrusted", &ProxyConfig{TrustedProxies: trusted}, "10.0.0.1:1234", map[string]string{HeaderXForwardedFor: "6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"invalid address", &ProxyConfig{TrustedProxies: trusted}, "10.0.0.1:1234", map[string]string{HeaderXForwardedFor: "1.2.3.4, bogus, 10.0.0.2"}, "10.0.0.2"},
+		{"no header", &ProxyConfig{TrustedProxies: trusted}, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"spoofed headers not configured", &ProxyConfig{TrustedProxies: trusted}, "10.0.0.1:1234",
+			map[string]string{HeaderForwarded: "for=6.6.6.6", HeaderXRealIP: "6.6.6.6", HeaderXForwardedFor: "1.2.3.4"}, "1.2.3.4"},
+		{"forwarded", &ProxyConfig{TrustedProxies: trusted, Header: HeaderForwarded}, "10.0.0.1:1234",
+			map[string]string{HeaderForwarded: `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`, HeaderXForwardedFor: "6.6.6.6"}, "2001:db8::1"},
+		{"x-real-ip", &ProxyConfig{TrustedProxies: trusted, Header: HeaderXRealIP}, "10.0.0.1:1234",
+			map[string]string{HeaderXRealIP: "1.2.3.4", HeaderXForwardedFor: "6.6.6.6"}, "1.2.3.4"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		c := tt.c
+		if c != nil {
+			c = CompileProxyConfig(c)
+		}
+		if got := ClientIP(r, c); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
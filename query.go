@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	QueryMask = "mask"
+	QueryHash = "hash"
+	QueryDrop = "drop"
+)
+
+// MaskRequestURI applies the query rules to the query string of requestURI.
+// rules maps a parameter name to "mask", "hash" or "drop". Masked values are computed by mask, or replaced by "***" if mask is nil.
+// Hashed values are an HMAC-SHA256 keyed by key, so that they cannot be found by hashing guesses; they are replaced by "***" if key is empty.
+func MaskRequestURI(requestURI string, rules map[string]string, key string, mask func(fieldName, s string) string) string {
+	if len(rules) == 0 {
+		return requestURI
+	}
+	i := strings.IndexByte(requestURI, '?')
+	if i < 0 {
+		return requestURI
+	}
+	query := MaskRawQuery(requestURI[i+1:], rules, key, mask)
+	if len(query) == 0 {
+		return requestURI[:i]
+	}
+	return requestURI[:i+1] + query
+}
+
+// MaskRawQuery applies the query rules to rawQuery, keeping the order of the parameters.
+func MaskRawQuery(rawQuery string, rules map[string]string, key string, mask func(fieldName, s string) string) string {
+	if len(rawQuery) == 0 || len(rules) == 0 {
+		return rawQuery
+	}
+	parts := strings.Split(rawQuery, "&")
+	masked := make([]string, 0, len(parts))
+	for _, part := range parts {
+		rawName, rawValue := part, ""
+		if j := strings.IndexByte(part, '='); j >= 0 {
+			rawName, rawValue = part[:j], part[j+1:]
+		}
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			name = rawName
+		}
+		rule, ok := queryRule(rules, name)
+		if !ok {
+			masked = append(masked, part)
+			continue
+		}
+		if rule == QueryDrop {
+			continue
+		}
+		v, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			v = rawValue
+		}
+		masked = append(masked, rawName+"="+url.QueryEscape(applyQueryRule(rule, name, v, key, mask)))
+	}
+	return strings.Join(masked, "&")
+}
+
+// BuildQuery returns the parameters of rawQuery after applying the query rules.
+// A parameter with one value is a string, with several values a []string.
+func BuildQuery(rawQuery string, rules map[string]string, key string, mask func(fieldName, s string) string) map[string]interface{} {
+	values, _ := url.ParseQuery(MaskRawQuery(rawQuery, rules, key, mask))
+	if len(values) == 0 {
+		return nil
+	}
+	query := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		if len(v) == 1 {
+			query[k] = v[0]
+		} else {
+			query[k] = v
+		}
+	}
+	return query
+}
+
+// ValidateQueryRules returns an error if a rule is not "mask", "hash" or "drop", or if a "hash" rule has no key.
+func ValidateQueryRules(rules map[string]string, key string) error {
+	for name, rule := range rules {
+		switch strings.ToLower(rule) {
+		case QueryMask, QueryDrop:
+		case QueryHash:
+			if len(key) == 0 {
+				return fmt.Errorf("query rule %q of %q requires a query key", rule, name)
+			}
+		default:
+			return fmt.Errorf("invalid query rule %q of %q", rule, name)
+		}
+	}
+	return nil
+}
+
+func queryRule(rules map[string]string, name string) (string, bool) {
+	if rule, ok := rules[name]; ok {
+		return strings.ToLower(rule), true
+	}
+	for k, rule := range rules {
+		if strings.EqualFold(k, name) {
+			return strings.ToLower(rule), true
+		}
+	}
+	return "", false
+}
+
+func applyQueryRule(rule string, name string, value string, key string, mask func(fieldName, s string) string) string {
+	switch rule {
+	case QueryHash:
+		if len(key) > 0 {
+			mac := hmac.New(sha256.New, []byte(key))
+			mac.Write([]byte(value))
+			return hex.EncodeToString(mac.Sum(nil))
+		}
+	case QueryMask:
+		if mask != nil {
+			return mask(name, value)
+		}
+	}
+	return DefaultRedaction
+}
@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestMaskRawQueryHashesWithQueryKey(t *testing.T) {
+	rules := map[string]string{"email": QueryHash}
+	a := MaskRawQuery("email=a%40b.com&page=1", rules, "secret1", nil)
+	b := MaskRawQuery("email=a%40b.com&page=1", rules, "secret2", nil)
+	if a == b {
+		t.Fatalf("same token under two query keys: %s", a)
+	}
+	if again := MaskRawQuery("email=a%40b.com&page=1", rules, "secret1", nil); again != a {
+		t.Fatalf("hash is not stable: %s and %s", a, again)
+	}
+	if MaskRawQuery("email=x", rules, "", nil) != "email="+url.QueryEscape(DefaultRedaction) {
+		t.Fatal("value hashed without a query key")
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import "testing"
+
+func TestConfigHolderKeepsConfigOnInvalidUpdate(t *testing.T) {
+	h, err := NewConfigHolder(LogConfig{Log: true, Duration: "elapsed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Update(LogConfig{Levels: &LevelConfig{Default: "verbose"}}); err == nil {
+		t.Fatal("no error for an invalid level")
+	}
+	if err := h.Update(LogConfig{Sampling: &SamplingConfig{Rate: 2}}); err == nil {
+		t.Fatal("no error for an invalid sample rate")
+	}
+	if c, fc := h.Load(); !c.Log || fc.Duration != "elapsed" {
+		t.Fatalf("config replaced by an invalid update: %+v", c)
+	}
+}
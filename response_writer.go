@@ -2,19 +2,52 @@ package middleware
 
 import (
 	"bytes"
+	"hash"
 	"net/http"
 )
 
 type ResponseWriter struct {
 	http.ResponseWriter
 	Body *bytes.Buffer
+	// Limit is the maximum number of bytes kept in Body, 0 for no limit. All bytes are still written to the client.
+	Limit int
+	// Hash, if set, is written with all bytes of the response.
+	Hash hash.Hash
+	// Timing records when the first and the last bytes were written.
+	Timing *WriteTiming
 }
 
-func NewResponseWriter(rw http.ResponseWriter) *ResponseWriter {
-	return &ResponseWriter{Body: bytes.NewBufferString(""), ResponseWriter: rw}
+func NewResponseWriter(rw http.ResponseWriter, opts ...int) *ResponseWriter {
+	var limit int
+	if len(opts) > 0 {
+		limit = opts[0]
+	}
+	return &ResponseWriter{Body: bytes.NewBufferString(""), ResponseWriter: rw, Limit: limit, Timing: &WriteTiming{}}
 }
 
 func (w ResponseWriter) Write(b []byte) (int, error) {
-	w.Body.Write(b)
+	w.Timing.mark()
+	w.capture(b)
 	return w.ResponseWriter.Write(b)
 }
+
+func (w ResponseWriter) WriteHeader(statusCode int) {
+	w.Timing.mark()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w ResponseWriter) capture(b []byte) {
+	if w.Hash != nil {
+		w.Hash.Write(b)
+	}
+	if w.Limit <= 0 {
+		w.Body.Write(b)
+		return
+	}
+	if remaining := w.Limit - w.Body.Len(); remaining > 0 {
+		if len(b) > remaining {
+			b = b[:remaining]
+		}
+		w.Body.Write(b)
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type ctxKeyRoute int
+
+// RouteKey is the key that holds the route template of a request, such as "/users/:id", in a request context.
+const RouteKey ctxKeyRoute = 0
+
+const routeRequestKey ctxKeyRoute = 1
+
+// routeRequest holds the last request passed to the next handler by the middlewares of this package.
+// http.ServeMux sets the pattern of the request it receives, which is a copy of the request seen by the outer
+// middlewares if an inner one replaced its context, so they read the pattern from this request once the handler returns.
+type routeRequest struct {
+	r *http.Request
+}
+
+// withRouteRequest returns r with a routeRequest in its context, if it has none.
+func withRouteRequest(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(routeRequestKey).(*routeRequest); ok {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), routeRequestKey, &routeRequest{}))
+}
+
+// routeHandler records the request passed to h in its routeRequest, if any.
+func routeHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if holder, ok := r.Context().Value(routeRequestKey).(*routeRequest); ok {
+			holder.r = r
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// WithRoute returns a shallow copy of r whose context holds route under RouteKey.
+func WithRoute(r *http.Request, route string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), RouteKey, route))
+}
+
+// GetRoute returns the route template of r: the value under RouteKey, or the pattern of http.ServeMux
+// without its method and host, which is set on Go 1.23+ once the request is routed.
+// Inside the Logger, Metrics and Audit middlewares, the pattern is read from the request passed to the handler,
+// so it is known once the handler returns, even if an inner middleware of this package copied the request.
+func GetRoute(r *http.Request) string {
+	if route, ok := r.Context().Value(RouteKey).(string); ok && len(route) > 0 {
+		return route
+	}
+	pattern := requestPattern(r)
+	if len(pattern) == 0 {
+		if holder, ok := r.Context().Value(routeRequestKey).(*routeRequest); ok && holder.r != nil {
+			pattern = requestPattern(holder.r)
+		}
+	}
+	if i := strings.IndexByte(pattern, ' '); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i+1:], " \t")
+	}
+	if i := strings.IndexByte(pattern, '/'); i > 0 {
+		pattern = pattern[i:]
+	}
+	return pattern
+}
+
+// BuildRouteField adds route into fields[c.Route], if both are set.
+func BuildRouteField(c LogConfig, route string, fields map[string]interface{}) {
+	if len(c.Route) > 0 && len(route) > 0 {
+		fields[c.Route] = route
+	}
+}
+
+// hasRouteRules reports whether a rule evaluated before the handler matches the route template,
+// which http.ServeMux sets only once it routes the request.
+func hasRouteRules(fc *FieldConfig) bool {
+	patterns := append([]PathPattern(nil), fc.SkipRules...)
+	if fc.Sampling != nil {
+		for _, rule := range fc.Sampling.Rules {
+			patterns = append(patterns, rule.PathPattern)
+		}
+	}
+	for _, rule := range fc.Captures {
+		patterns = append(patterns, rule.PathPattern)
+	}
+	for _, p := range patterns {
+		if p.Route {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+//go:build !go1.23
+
+package middleware
+
+import "net/http"
+
+func requestPattern(r *http.Request) string {
+	return ""
+}
+
+func pathValue(r *http.Request, name string) string {
+	return ""
+}
@@ -0,0 +1,73 @@
+//go:build go1.23
+
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRouteOfServeMuxBehindCopyingMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	m := NewMetrics(MetricsConfig{})
+	h := m.Middleware(RequestID(RequestIDConfig{})(mux))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `route="/users/{id}"`) {
+		t.Fatalf("route of the ServeMux pattern not recorded:\n%s", w.Body.String())
+	}
+}
+
+func TestLoggerReportsRouteRulesBeforeRouting(t *testing.T) {
+	log := func(ctx context.Context, msg string, fields map[string]interface{}) {}
+	skip := LogConfig{Log: true, SkipRules: []PathPattern{{Path: "/users/{id}", Route: true}}}
+	newMux := func(h http.Handler) *http.ServeMux {
+		mux := http.NewServeMux()
+		mux.Handle("GET /users/{id}", h)
+		return mux
+	}
+	noop := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// outside the mux, the rule cannot match and the misconfiguration is reported
+	outside := NewHttpLogger(skip, log, NewLogger(), nil)
+	outside.Logger(newMux(noop)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if outside.RouteErrors() != 1 {
+		t.Fatalf("route errors %d, want 1", outside.RouteErrors())
+	}
+
+	// rules swapped in by a ConfigHolder are reported as well
+	holder, err := NewConfigHolder(LogConfig{Log: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewHttpLogger(LogConfig{Log: true}, log, NewLogger(), nil)
+	reloaded.Holder = holder
+	h := reloaded.Logger(newMux(noop))
+	if err := holder.Update(skip); err != nil {
+		t.Fatal(err)
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if reloaded.RouteErrors() != 1 {
+		t.Fatalf("route errors %d after reload, want 1", reloaded.RouteErrors())
+	}
+
+	// inside the mux, the route is known and the request is skipped
+	logged := make(chan string, 1)
+	inside := NewHttpLogger(skip, func(ctx context.Context, msg string, fields map[string]interface{}) { logged <- msg }, NewLogger(), nil)
+	newMux(inside.Logger(noop)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if inside.RouteErrors() != 0 {
+		t.Fatalf("route errors %d inside the mux, want 0", inside.RouteErrors())
+	}
+	select {
+	case msg := <-logged:
+		t.Fatalf("request logged inside the mux: %s", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSampleField    = "sample_rate"
+	DefaultSampledByField = "sampled_by"
+
+	// SampledByTrace is the value of SamplingConfig.ByField for a record sampled by the Trace of its request.
+	SampledByTrace = "trace"
+)
+
+// SamplingConfig keeps a fraction of the request logs. Rate is the head-sampling rate of routes without a matching rule:
+// 0 keeps no request, 1 keeps all. Responses matching Status or slower than Latency milliseconds are always kept.
+type SamplingConfig struct {
+	Rate  float64      `yaml:"rate" mapstructure:"rate" json:"rate,omitempty" gorm:"column:rate" bson:"rate,omitempty" dynamodbav:"rate,omitempty" firestore:"rate,omitempty"`
+	Rules []SampleRule `yaml:"rules" mapstructure:"rules" json:"rules,omitempty" gorm:"column:rules" bson:"rules,omitempty" dynamodbav:"rules,omitempty" firestore:"rules,omitempty"`
+	// Trace follows the sampled flag of the Trace of the request, if there is one, instead of Rate and Rules.
+	// Such a record has no rate, as the rate applied by the parent is unknown: ByField is set to "trace" instead.
+	Trace bool `yaml:"trace" mapstructure:"trace" json:"trace,omitempty" gorm:"column:trace" bson:"trace,omitempty" dynamodbav:"trace,omitempty" firestore:"trace,omitempty"`
+	// Key makes the decision deterministic: "request_id" samples by GetReqID, another value by the context value of that key.
+	// Requests without a key value are sampled randomly.
+	Key string `yaml:"key" mapstructure:"key" json:"key,omitempty" gorm:"column:key" bson:"key,omitempty" dynamodbav:"key,omitempty" firestore:"key,omitempty"`
+	// Status lists status classes such as "5xx" or status codes such as "429" that are always kept.
+	Status  []string `yaml:"status" mapstructure:"status" json:"status,omitempty" gorm:"column:status" bson:"status,omitempty" dynamodbav:"status,omitempty" firestore:"status,omitempty"`
+	Latency int64    `yaml:"latency" mapstructure:"latency" json:"latency,omitempty" gorm:"column:latency" bson:"latency,omitempty" dynamodbav:"latency,omitempty" firestore:"latency,omitempty"`
+	// Field is the log field of the applied sample rate, "sample_rate" by default. A record kept by Status or Latency has rate 1.
+	Field string `yaml:"field" mapstructure:"field" json:"field,omitempty" gorm:"column:field" bson:"field,omitempty" dynamodbav:"field,omitempty" firestore:"field,omitempty"`
+	// ByField is the log field set to "trace" instead of Field when the Trace decided, "sampled_by" by default.
+	ByField string `yaml:"by_field" mapstructure:"by_field" json:"byField,omitempty" gorm:"column:byfield" bson:"byField,omitempty" dynamodbav:"byField,omitempty" firestore:"byField,omitempty"`
+}
+
+type SampleRule struct {
+	PathPattern `yaml:",inline" mapstructure:",squash"`
+	Rate        float64 `yaml:"rate" mapstructure:"rate" json:"rate,omitempty" gorm:"column:rate" bson:"rate,omitempty" dynamodbav:"rate,omitempty" firestore:"rate,omitempty"`
+}
+
+// CompileSamplingConfig validates c and returns a copy with compiled rules.
+func CompileSamplingConfig(c *SamplingConfig) (*SamplingConfig, error) {
+	if c == nil {
+		return nil, nil
+	}
+	s := *c
+	if len(s.Field) == 0 {
+		s.Field = DefaultSampleField
+	}
+	if len(s.ByField) == 0 {
+		s.ByField = DefaultSampledByField
+	}
+	if s.Rate < 0 || s.Rate > 1 {
+		return nil, fmt.Errorf("invalid sample rate %v", s.Rate)
+	}
+	for _, status := range s.Status {
+		if !isStatusPattern(status) {
+			return nil, fmt.Errorf("invalid sample status %q", status)
+		}
+	}
+	if len(c.Rules) > 0 {
+		s.Rules = make([]SampleRule, len(c.Rules))
+		for i, rule := range c.Rules {
+			if rule.Rate < 0 || rule.Rate > 1 {
+				return nil, fmt.Errorf("invalid sample rate %v of pattern %q", rule.Rate, rule.Path)
+			}
+			if err := rule.Compile(); err != nil {
+				return nil, err
+			}
+			s.Rules[i] = rule
+		}
+	}
+	return &s, nil
+}
+
+// HeadSample returns the sample rate applied to r, whether r is kept by head sampling, and whether the Trace of r decided,
+// in which case the rate is unknown. c must be compiled.
+func HeadSample(r *http.Request, c *SamplingConfig) (float64, bool, bool) {
+	rate := c.Rate
+	for i := range c.Rules {
+		if c.Rules[i].Matches(r) {
+			rate = c.Rules[i].Rate
+			break
+		}
+	}
+	if c.Trace {
+		if t, ok := GetTrace(r.Context()); ok {
+			return 0, t.Sampled(), true
+		}
+	}
+	if rate >= 1 {
+		return rate, true, false
+	}
+	if rate <= 0 {
+		return rate, false, false
+	}
+	if key := sampleKey(r.Context(), c.Key); len(key) > 0 {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		return rate, float64(mix64(h.Sum64())>>11)/(1<<53) < rate, false
+	}
+	return rate, rand.Float64() < rate, false
+}
+
+// BuildSampleFields adds the sample rate of a record to fields, or marks it as sampled by the trace, whose rate is unknown.
+func BuildSampleFields(c *SamplingConfig, rate float64, byTrace bool, fields map[string]interface{}) {
+	if byTrace {
+		delete(fields, c.Field)
+		fields[c.ByField] = SampledByTrace
+		return
+	}
+	delete(fields, c.ByField)
+	fields[c.Field] = rate
+}
+
+// TailSample reports whether a response is always kept, because of its status or duration.
+func TailSample(c *SamplingConfig, status int, duration time.Duration) bool {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if c.Latency > 0 && duration.Milliseconds() > c.Latency {
+		return true
+	}
+	return MatchStatus(status, c.Status)
+}
+
+// MatchStatus reports whether status matches one of patterns, such as "5xx" or "429".
+func MatchStatus(status int, patterns []string) bool {
+	s := strconv.Itoa(status)
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if len(p) == 3 && p[1:] == "xx" && len(s) == 3 && p[0] == s[0] {
+			return true
+		}
+		if p == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isStatusPattern(p string) bool {
+	p = strings.ToLower(strings.TrimSpace(p))
+	if len(p) != 3 || p[0] < '1' || p[0] > '5' {
+		return false
+	}
+	if p[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(p)
+	return err == nil
+}
+
+// mix64 is the splitmix64 finalizer, to spread keys that differ only in their last bytes.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func sampleKey(ctx context.Context, key string) string {
+	if len(key) == 0 {
+		return ""
+	}
+	if key == "request_id" {
+		return GetReqID(ctx)
+	}
+	if v, ok := ctx.Value(key).(string); ok {
+		return v
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceSampledRecordHasNoRate(t *testing.T) {
+	c, err := CompileSamplingConfig(&SamplingConfig{Rate: 0.5, Trace: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), TraceKey, Trace{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", Flags: 1}))
+	rate, sampled, byTrace := HeadSample(r, c)
+	if !sampled || !byTrace {
+		t.Fatalf("sampled %v, by trace %v, want the decision of the trace", sampled, byTrace)
+	}
+	fields := make(map[string]interface{})
+	BuildSampleFields(c, rate, byTrace, fields)
+	if _, ok := fields[c.Field]; ok || fields[c.ByField] != SampledByTrace {
+		t.Fatalf("fields of a record sampled by the trace: %v", fields)
+	}
+	// a record kept by its status has rate 1
+	BuildSampleFields(c, 1, false, fields)
+	if _, ok := fields[c.ByField]; ok || fields[c.Field] != 1.0 {
+		t.Fatalf("fields of a record kept by its status: %v", fields)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build go1.21
+
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogGroupsWithReloadedConfig(t *testing.T) {
+	h, err := NewConfigHolder(LogConfig{Log: true, Uri: "uri"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	l := NewHttpLoggerWithSlog(LogConfig{Log: true, Uri: "uri"}, logger, nil, nil)
+	l.Holder = h
+	levels := NewSlogLevelLoggerWithHolder(h, logger)
+	if err := h.Update(LogConfig{Log: true, Uri: "path"}); err != nil {
+		t.Fatal(err)
+	}
+	for name, log := range map[string]func(ctx context.Context, msg string, fields map[string]interface{}){
+		"NewHttpLoggerWithSlog":        l.LogInfo,
+		"NewSlogLevelLoggerWithHolder": levels.Info,
+	} {
+		buf.Reset()
+		log(context.Background(), "request", map[string]interface{}{"path": "/a"})
+		if !strings.Contains(buf.String(), `"request":{"path":"/a"}`) {
+			t.Errorf("%s: field not grouped with the reloaded config: %s", name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscalatedTruncatedBodiesAreNotLoggedUnmasked(t *testing.T) {
+	sc, err := CompileSlowConfig(&SlowConfig{Threshold: 1, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := `{"user":"a","password":"secret"}`
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(full))
+	r.Header.Set("Content-Type", "application/json")
+	body := CaptureSlowRequest(r, sc, nil)
+	io.ReadAll(r.Body)
+
+	ww := NewWrapResponseWriter(httptest.NewRecorder(), 1)
+	ww.Write([]byte(full))
+	fields := make(map[string]interface{})
+	c, response := EscalateSlow(LogConfig{}, sc, r, body, full, fields)
+	MaskRequest(c.Request, fields, maskPassword, false)
+	MaskResponse(ww, c, time.Now(), response, fields, maskPassword, false)
+	for _, key := range []string{c.Request, c.Response} {
+		b, ok := fields[key].(TruncatedBody)
+		if !ok || len(b.Body) > 0 || b.Size != int64(len(full)) {
+			t.Fatalf("escalated %s is logged: %#v", key, fields[key])
+		}
+	}
+}
+
+func TestEscalatedLevelIsSent(t *testing.T) {
+	sent := make(chan []byte, 1)
+	send := func(ctx context.Context, b []byte, attrs map[string]string) error {
+		sent <- b
+		return nil
+	}
+	f := NewMaskLoggerWithSending("request", maskPassword, maskPassword, false, send)
+	log := func(ctx context.Context, msg string, fields map[string]interface{}) {}
+	c := LogConfig{Log: true, Build: true, Slow: &SlowConfig{Threshold: 1}}
+	h := NewHttpLogger(c, log, f, nil).Logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders", nil))
+	select {
+	case b := <-sent:
+		if !strings.Contains(string(b), `"level":"warn"`) || !strings.Contains(string(b), `"slow":true`) {
+			t.Fatalf("escalated record not sent at warn: %s", b)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no record sent")
+	}
+}